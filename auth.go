@@ -22,6 +22,10 @@ const (
 	sessionTokenLength = 30
 
 	defaultSessionExpirySeconds = 30 * 24 * 3600
+
+	// defaultLongSessionExpirySeconds is the default for Central.LongSessionExpiresAfter,
+	// used for sessions created with LoginOptions.StayLoggedIn set.
+	defaultLongSessionExpirySeconds = 30 * 24 * 3600
 )
 
 var (
@@ -36,6 +40,7 @@ var (
 	ErrIdentityExists         = errors.New("Identity already exists")
 	ErrInvalidPassword        = errors.New("Invalid password")
 	ErrInvalidSessionToken    = errors.New("Invalid session token")
+	ErrIdentityLocked         = errors.New("Identity is locked")
 )
 
 // Use this whenever you return an Authaus error. We rely upon the prefix
@@ -85,8 +90,50 @@ type Token struct {
 	Identity string
 	Expires  time.Time
 	Permit   Permit
+
+	// IdentityVersion is only meaningful for signed session tokens (see TokenSigner). It lets
+	// SetPermit/SetPassword invalidate outstanding tokens without a database round-trip on every
+	// request: a token is rejected once its IdentityVersion falls behind the identity's current
+	// version. Opaque, database-backed sessions leave this at zero.
+	IdentityVersion uint64
+
+	// Stage is TokenStageFull for an ordinary, usable session token. It is TokenStagePending
+	// for a token that only proves a successful password check, and is awaiting a second
+	// factor via Central.CompleteMFALogin; GetTokenFromSession refuses pending tokens.
+	Stage TokenStage
+
+	// IssuedAt is when this session was created. Lifetime says which expiry policy governs
+	// it (see LoginOptions.StayLoggedIn), and ClientInfo records who asked for it (typically a
+	// user-agent and/or IP address), so that ListSessionsForIdentity has something to show.
+	IssuedAt   time.Time
+	Lifetime   SessionLifetimeClass
+	ClientInfo string
+
+	// LastSeen is refreshed by GetTokenFromSession whenever Central.IdleSessionTimeout is
+	// configured, so that SessionLifetimeStandard tokens can be expired for inactivity
+	// independently of their absolute Expires. Backends that can't persist the refresh (signed
+	// tokens) simply don't get one, and idle timeout degrades to counting from IssuedAt.
+	LastSeen time.Time
 }
 
+// SessionLifetimeClass distinguishes an ordinary session from a "remember me" one, so that
+// GetTokenFromSession can apply a different expiry policy to each.
+type SessionLifetimeClass int
+
+const (
+	SessionLifetimeStandard SessionLifetimeClass = iota
+	SessionLifetimeLong
+)
+
+// TokenStage distinguishes a fully-authenticated session token from one that is still waiting
+// on a second authentication factor.
+type TokenStage int
+
+const (
+	TokenStageFull TokenStage = iota
+	TokenStagePending
+)
+
 // Transform an identity into its canonical form. What this means is that any two identities
 // are considered equal if their canonical forms are equal. This is simply a lower-casing
 // of the identity, so that "bob@enterprise.com" is equal to "Bob@enterprise.com".
@@ -167,15 +214,56 @@ For lack of a better name, this is the single hub of authentication that you int
 All public methods of Central are callable from multiple threads.
 */
 type Central struct {
-	authenticator          Authenticator
-	permitDB               PermitDB
-	sessionDB              SessionDB
+	authenticator Authenticator
+	permitDB      PermitDB
+	sessionDB     SessionDB
+	// rawSessionDB is the SessionDB passed to NewCentral/EnableSignedSessions, before it gets
+	// wrapped in a cachedSessionDB. ListSessionsForIdentity type-asserts against this instead of
+	// sessionDB, since cachedSessionDB doesn't implement SessionLister itself, and would hide
+	// the wrapped store's own implementation behind the type assertion otherwise.
+	rawSessionDB           SessionDB
 	roleGroupDB            RoleGroupDB
 	logFile                *os.File
 	Log                    *log.Logger
 	Stats                  CentralStats
 	MaxActiveSessions      int32
 	NewSessionExpiresAfter time.Duration
+
+	// LongSessionExpiresAfter governs sessions created with LoginOptions.StayLoggedIn set
+	// (e.g. 30 days), instead of NewSessionExpiresAfter.
+	LongSessionExpiresAfter time.Duration
+
+	// IdleSessionTimeout, if non-zero, expires SessionLifetimeStandard tokens after this long
+	// without activity, independent of their absolute Expires. It does not apply to
+	// SessionLifetimeLong ("remember me") tokens, which only expire absolutely.
+	IdleSessionTimeout time.Duration
+
+	// tokenSigner is non-nil once EnableSignedSessions has been called. When set, Login issues
+	// signed tokens via tokenSigner.Sign instead of opaque session keys.
+	tokenSigner TokenSigner
+	// legacySessionDB, when non-nil, is consulted by GetTokenFromSession if the primary
+	// sessionDB doesn't recognize a token. This lets opaque sessions created before signed
+	// tokens were enabled keep working until they expire naturally.
+	legacySessionDB SessionDB
+
+	// lockedUsersDB and LockoutPolicy are nil/zero unless EnableLockoutPolicy has been called,
+	// in which case GetTokenFromIdentityPassword and Login guard authentication with a
+	// brute-force lockout check.
+	lockedUsersDB   LockedUsersDB
+	LockoutPolicy   LockoutPolicy
+	lockoutSweepEnd chan bool
+	// lockoutSweepDone is closed by sweepExpiredLockouts right before it returns, so that Close
+	// can wait for the goroutine to actually exit before nulling out lockedUsersDB/Log - without
+	// this, a ticker fire racing with close(lockoutSweepEnd) could pick the ticker case and then
+	// use fields Close has already torn down.
+	lockoutSweepDone chan struct{}
+
+	// mfaProvider and mfaDB are nil unless EnableMFA has been called, in which case Login
+	// returns a pending token for any identity that mfaDB reports as MFA-required (see
+	// SetMFARequired), instead of a full session.
+	mfaProvider         MFAProvider
+	mfaDB               MFADB
+	PreAuthExpiresAfter time.Duration
 }
 
 // Create a new Central object from the specified pieces.
@@ -186,12 +274,14 @@ func NewCentral(logger *log.Logger, authenticator Authenticator, permitDB Permit
 		backend: authenticator,
 	}
 	c.permitDB = permitDB
+	c.rawSessionDB = sessionDB
 	c.sessionDB = newCachedSessionDB(sessionDB)
 	if roleGroupDB != nil {
 		c.roleGroupDB = NewCachedRoleGroupDB(roleGroupDB)
 	}
 	c.MaxActiveSessions = 0
 	c.NewSessionExpiresAfter = time.Duration(defaultSessionExpirySeconds) * time.Second
+	c.LongSessionExpiresAfter = time.Duration(defaultLongSessionExpirySeconds) * time.Second
 	c.Log = logger
 	c.Log.Printf("Authaus successfully started up\n")
 	return c
@@ -252,15 +342,29 @@ func NewCentralFromConfig(config *Config) (central *Central, err error) {
 		panic(err)
 	}
 
-	if permitDB, err = NewPermitDB_SQL(&config.PermitDB.DB); err != nil {
+	if config.PermitDB.Backend == "mongo" {
+		permitDB, err = NewPermitDB_Mongo(&config.PermitDB.Mongo)
+	} else {
+		permitDB, err = NewPermitDB_SQL(&config.PermitDB.DB)
+	}
+	if err != nil {
 		panic(errors.New(fmt.Sprintf("Error connecting to PermitDB: %v", err)))
 	}
 
-	if sessionDB, err = NewSessionDB_SQL(&config.SessionDB.DB); err != nil {
+	if config.SessionDB.Backend == "mongo" {
+		sessionDB, err = NewSessionDB_Mongo(&config.SessionDB.Mongo)
+	} else {
+		sessionDB, err = NewSessionDB_SQL(&config.SessionDB.DB)
+	}
+	if err != nil {
 		panic(errors.New(fmt.Sprintf("Error connecting to SessionDB: %v", err)))
 	}
 
-	if config.RoleGroupDB.DB.Driver != "" {
+	if config.RoleGroupDB.Backend == "mongo" {
+		if roleGroupDB, err = NewRoleGroupDB_Mongo(&config.RoleGroupDB.Mongo); err != nil {
+			panic(errors.New(fmt.Sprintf("Error connecting to RoleGroupDB: %v", err)))
+		}
+	} else if config.RoleGroupDB.DB.Driver != "" {
 		if roleGroupDB, err = NewRoleGroupDB_SQL(&config.RoleGroupDB.DB); err != nil {
 			panic(errors.New(fmt.Sprintf("Error connecting to RoleGroupDB: %v", err)))
 		}
@@ -272,6 +376,50 @@ func NewCentralFromConfig(config *Config) (central *Central, err error) {
 	if config.SessionDB.SessionExpirySeconds != 0 {
 		c.NewSessionExpiresAfter = time.Duration(config.SessionDB.SessionExpirySeconds) * time.Second
 	}
+	if config.SessionDB.LongSessionExpirySeconds != 0 {
+		c.LongSessionExpiresAfter = time.Duration(config.SessionDB.LongSessionExpirySeconds) * time.Second
+	}
+	if config.SessionDB.IdleSessionTimeoutSeconds != 0 {
+		c.IdleSessionTimeout = time.Duration(config.SessionDB.IdleSessionTimeoutSeconds) * time.Second
+	}
+
+	if config.SessionDB.Signing.Enabled {
+		signer, eSigner := newTokenSignerFromConfig(&config.SessionDB.Signing)
+		if eSigner != nil {
+			panic(eSigner)
+		}
+		versions, eVersions := NewIdentityVersionDB_SQL(&config.SessionDB.DB)
+		if eVersions != nil {
+			panic(errors.New(fmt.Sprintf("Error connecting to IdentityVersionDB: %v", eVersions)))
+		}
+		revoked, eRevoked := NewRevokedSessionDB_SQL(&config.SessionDB.DB)
+		if eRevoked != nil {
+			panic(errors.New(fmt.Sprintf("Error connecting to RevokedSessionDB: %v", eRevoked)))
+		}
+		var legacy SessionDB
+		if config.SessionDB.Signing.AcceptLegacyOpaqueTokens {
+			legacy = sessionDB
+		}
+		c.EnableSignedSessions(signer, versions, revoked, legacy)
+	}
+
+	if config.Lockout.Enabled {
+		lockedUsersDB, eLockout := NewLockedUsersDB_SQL(&config.PermitDB.DB)
+		if eLockout != nil {
+			panic(errors.New(fmt.Sprintf("Error connecting to LockedUsersDB: %v", eLockout)))
+		}
+		c.EnableLockoutPolicy(config.Lockout.Policy, lockedUsersDB)
+	}
+
+	if config.MFA.Enabled {
+		mfaDB, eMFA := NewMFADB_SQL(&config.PermitDB.DB)
+		if eMFA != nil {
+			panic(errors.New(fmt.Sprintf("Error connecting to MFADB: %v", eMFA)))
+		}
+		provider := NewTOTPProvider(mfaDB, config.MFA.Issuer)
+		c.EnableMFA(provider, mfaDB, time.Duration(config.MFA.PreAuthExpirySeconds)*time.Second)
+	}
+
 	return c, nil
 }
 
@@ -298,33 +446,244 @@ func createAuthenticator(config *ConfigAuthenticator) (Authenticator, error) {
 			return nil, errors.New(fmt.Sprintf("Unable to connect to AuthenticationDB: %v", err))
 		}
 		return auth, nil
+	case "mongo":
+		if auth, err = NewAuthenticationDB_Mongo(&config.Mongo); err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to connect to AuthenticationDB (mongo): %v", err))
+		}
+		return auth, nil
 	case "dummy":
 		return newDummyAuthenticator(), nil
+	case "cert":
+		var inner Authenticator
+		if config.Cert.Inner != nil {
+			if inner, err = createAuthenticator(config.Cert.Inner); err != nil {
+				return nil, errors.New(fmt.Sprintf("Error creating inner Authenticator for cert auth: %v", err))
+			}
+		}
+		var certAuth *ClientCertAuthenticator
+		if certAuth, err = NewAuthenticator_ClientCert(config.Cert.TrustedCAs, config.Cert.IdentityTemplate, inner); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error creating ClientCert Authenticator: %v", err))
+		}
+		certAuth.RequireExistingIdentity = config.Cert.RequireExistingIdentity
+		return certAuth, nil
 	default:
 		return nil, errors.New("Unrecognized Authenticator type '" + config.Type + "'")
 	}
 }
 
-// Set the size of the in-memory session cache
+// Set the size of the in-memory session cache. A no-op once EnableSignedSessions has swapped
+// sessionDB for a *SignedSessionDB, which has no cache to size.
 func (x *Central) SetSessionCacheSize(maxSessions int) {
-	x.sessionDB.(*cachedSessionDB).MaxCachedSessions = maxSessions
+	if cached, ok := x.sessionDB.(*cachedSessionDB); ok {
+		cached.MaxCachedSessions = maxSessions
+	}
+}
+
+// EnableSignedSessions switches Central over to issuing and verifying cryptographically
+// signed session tokens (see TokenSigner), instead of the opaque random strings normally
+// produced by generateSessionKey(). The signature is then checked locally, but reads still make
+// database round-trips: every read asks RevokedSessionDB whether that one token was revoked, and
+// the identity's current version is fetched from IdentityVersionDB at most once per
+// SignedSessionDB.VersionCacheTTL (see SignedSessionDB). If 'legacy' is non-nil, GetTokenFromSession
+// keeps accepting tokens from it, so that sessions created before the switch keep working until
+// they expire naturally.
+func (x *Central) EnableSignedSessions(signer TokenSigner, versions IdentityVersionDB, revoked RevokedSessionDB, legacy SessionDB) {
+	x.tokenSigner = signer
+	x.legacySessionDB = legacy
+	x.sessionDB = NewSignedSessionDB(signer, versions, revoked)
+	x.rawSessionDB = x.sessionDB
+}
+
+// EnableLockoutPolicy turns on brute-force lockout tracking, backed by lockedUsersDB. Once
+// enabled, GetTokenFromIdentityPassword and Login consult lockedUsersDB before attempting to
+// authenticate, and record failures/successes against it afterwards. It also starts a
+// background goroutine that periodically purges expired lockout records; call Close() on
+// Central to stop it.
+func (x *Central) EnableLockoutPolicy(policy LockoutPolicy, lockedUsersDB LockedUsersDB) {
+	x.LockoutPolicy = policy
+	x.lockedUsersDB = lockedUsersDB
+	x.lockoutSweepEnd = make(chan bool)
+	x.lockoutSweepDone = make(chan struct{})
+	go x.sweepExpiredLockouts()
+}
+
+// UnlockIdentity clears any lockout recorded against 'identity', regardless of how it got
+// there (threshold lockout or permanent lock).
+func (x *Central) UnlockIdentity(identity string) error {
+	if x.lockedUsersDB == nil {
+		return nil
+	}
+	return x.lockedUsersDB.Unlock(CanonicalizeIdentity(identity))
+}
+
+// ListLockedIdentities returns every identity that is currently locked out.
+func (x *Central) ListLockedIdentities() ([]LockedIdentity, error) {
+	if x.lockedUsersDB == nil {
+		return nil, nil
+	}
+	return x.lockedUsersDB.ListLocked()
+}
+
+func (x *Central) sweepExpiredLockouts() {
+	defer close(x.lockoutSweepDone)
+	interval := x.LockoutPolicy.SweepInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-x.lockoutSweepEnd:
+			return
+		case <-ticker.C:
+			if err := x.lockedUsersDB.PurgeExpired(); err != nil {
+				x.Log.Printf("Lockout sweep failed (%v)", err)
+			}
+		}
+	}
+}
+
+// EnableMFA turns on TOTP second-factor login, backed by provider and db. Once enabled, Login
+// returns a pending token (Token.Stage == TokenStagePending) for any identity that db reports
+// as MFA-required; the caller must then present the TOTP code to CompleteMFALogin to obtain a
+// full session. preAuthExpiry controls how long the pending token remains valid.
+func (x *Central) EnableMFA(provider MFAProvider, db MFADB, preAuthExpiry time.Duration) {
+	x.mfaProvider = provider
+	x.mfaDB = db
+	x.PreAuthExpiresAfter = preAuthExpiry
+}
+
+// SetMFARequired turns TOTP second-factor login on or off for a single identity.
+func (x *Central) SetMFARequired(identity string, required bool) error {
+	if x.mfaDB == nil {
+		return ErrUnsupported
+	}
+	return x.mfaDB.SetMFARequired(CanonicalizeIdentity(identity), required)
+}
+
+func (x *Central) mfaRequiredFor(identity string) bool {
+	if x.mfaProvider == nil || x.mfaDB == nil {
+		return false
+	}
+	required, err := x.mfaDB.IsMFARequired(CanonicalizeIdentity(identity))
+	if err != nil {
+		x.Log.Printf("IsMFARequired failed (%v) (%v)", identity, err)
+		return false
+	}
+	return required
+}
+
+// issuePendingToken creates a short-lived, Stage == TokenStagePending session for 'token',
+// which must already have Identity and Permit set. The resulting sessionkey is only good for
+// CompleteMFALogin, since GetTokenFromSession refuses pending tokens.
+func (x *Central) issuePendingToken(token *Token, options LoginOptions) (sessionkey string, result *Token, e error) {
+	token.Stage = TokenStagePending
+	token.IssuedAt = time.Now()
+	// Stash the caller's desired final lifetime in the pending token itself - there is nowhere
+	// else to keep it across the trip out to the user and back with an MFA code - and restore
+	// it in CompleteMFALogin once the second factor checks out.
+	token.Lifetime, _ = x.sessionLifetime(options)
+	expiry := x.PreAuthExpiresAfter
+	if expiry <= 0 {
+		expiry = 5 * time.Minute
+	}
+	token.Expires = time.Now().Add(expiry)
+	sessionkey, e = x.issueSession(token)
+	if e != nil {
+		return "", nil, e
+	}
+	return sessionkey, token, nil
+}
+
+// CompleteMFALogin finalizes a login that was put on hold by Login returning a pending token.
+// 'preAuthToken' is the sessionkey Login returned, and 'code' is the TOTP (or backup) code the
+// user entered. On success it returns a full session with the ClientInfo and lifetime class that
+// Login would have given it without MFA, expiring after LongSessionExpiresAfter or
+// NewSessionExpiresAfter according to that class. A LoginOptions.MaxAge passed to the original
+// Login is not carried over and does not affect the full session's expiry.
+func (x *Central) CompleteMFALogin(preAuthToken, code string) (sessionkey string, token *Token, e error) {
+	if x.mfaProvider == nil {
+		return "", nil, ErrUnsupported
+	}
+	pending, e := x.sessionDB.Read(preAuthToken)
+	if e != nil {
+		return "", nil, ErrInvalidSessionToken
+	}
+	if pending.Stage != TokenStagePending {
+		return "", nil, ErrInvalidSessionToken
+	}
+	if time.Now().After(pending.Expires) {
+		return "", nil, ErrInvalidSessionToken
+	}
+	if e = x.mfaProvider.Verify(pending.Identity, code); e != nil {
+		return "", nil, e
+	}
+	x.sessionDB.Delete(preAuthToken)
+	full := &Token{Identity: pending.Identity, Permit: pending.Permit, ClientInfo: pending.ClientInfo}
+	full.Stage = TokenStageFull
+	full.IssuedAt = time.Now()
+	full.Lifetime = pending.Lifetime
+	if full.Lifetime == SessionLifetimeLong {
+		full.Expires = time.Now().Add(x.LongSessionExpiresAfter)
+	} else {
+		full.Expires = time.Now().Add(x.NewSessionExpiresAfter)
+	}
+	if x.MaxActiveSessions != 0 {
+		if e = x.sessionDB.InvalidateSessionsForIdentity(pending.Identity); e != nil {
+			x.Log.Printf("Invalidate sessions for identity (%v) failed when enforcing MaxActiveSessions (%v)", pending.Identity, e)
+			return "", nil, e
+		}
+	}
+	if sessionkey, e = x.issueSession(full); e != nil {
+		return "", nil, e
+	}
+	x.Stats.IncrementGoodLogin(x.Log)
+	x.Log.Printf("MFA login successful (%v)", pending.Identity)
+	return sessionkey, full, nil
 }
 
 // Pass in a session key that was generated with a call to Login(), and get back a token.
-// A session key is typically a cookie.
+// A session key is typically a cookie. This transparently accepts both opaque session keys
+// and signed tokens (see EnableSignedSessions).
 func (x *Central) GetTokenFromSession(sessionkey string) (*Token, error) {
-	if token, err := x.sessionDB.Read(sessionkey); err != nil {
+	token, err := x.sessionDB.Read(sessionkey)
+	if err != nil && x.legacySessionDB != nil {
+		token, err = x.legacySessionDB.Read(sessionkey)
+	}
+	if err != nil {
 		x.Stats.IncrementInvalidSessionKey(x.Log)
 		return token, err
-	} else {
-		if time.Now().UnixNano() > token.Expires.UnixNano() {
-			// DB has not yet expired token. It's OK for the DB to be a bit lazy in its cleanup.
+	}
+	if time.Now().UnixNano() > token.Expires.UnixNano() {
+		// DB has not yet expired token. It's OK for the DB to be a bit lazy in its cleanup.
+		x.Stats.IncrementExpiredSessionKey(x.Log)
+		return nil, ErrInvalidSessionToken
+	}
+	if token.Stage == TokenStagePending {
+		// A pending token only proves that the password check passed; it must not be handed
+		// to protected endpoints until CompleteMFALogin has verified the second factor.
+		x.Stats.IncrementInvalidSessionKey(x.Log)
+		return nil, ErrInvalidSessionToken
+	}
+	if x.IdleSessionTimeout != 0 && token.Lifetime == SessionLifetimeStandard {
+		// "Remember me" (SessionLifetimeLong) sessions only ever expire absolutely, via
+		// token.Expires above; the idle timeout is an extra, sliding-window policy that only
+		// applies to ordinary sessions.
+		lastSeen := token.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = token.IssuedAt
+		}
+		if !lastSeen.IsZero() && time.Since(lastSeen) > x.IdleSessionTimeout {
 			x.Stats.IncrementExpiredSessionKey(x.Log)
 			return nil, ErrInvalidSessionToken
-		} else {
-			return token, err
+		}
+		token.LastSeen = time.Now()
+		if werr := x.sessionDB.Write(sessionkey, token); werr != nil {
+			x.Log.Printf("Failed to refresh idle session (%v) (%v)", token.Identity, werr)
 		}
 	}
+	return token, nil
 }
 
 // Perform a once-off authentication
@@ -337,7 +696,11 @@ func (x *Central) GetTokenFromIdentityPassword(identity, password string) (*Toke
 		x.Stats.IncrementEmptyIdentities(x.Log)
 		return nil, ErrIdentityEmpty
 	}
+	if eLock := x.checkLockout(identity); eLock != nil {
+		return nil, eLock
+	}
 	if eAuth := x.authenticator.Authenticate(identity, password); eAuth == nil {
+		x.recordAuthOutcome(identity, true)
 		if permit, ePermit := x.permitDB.GetPermit(identity); ePermit == nil {
 			t := &Token{}
 			t.Expires = veryFarFuture
@@ -353,33 +716,62 @@ func (x *Central) GetTokenFromIdentityPassword(identity, password string) (*Toke
 	} else {
 		x.Stats.IncrementInvalidPasswords(x.Log)
 		x.Log.Printf("Once-off auth Authentication failed (%v) (%v)", identity, eAuth)
+		if eAuth == ErrInvalidPassword {
+			x.recordAuthOutcome(identity, false)
+		}
 		return nil, eAuth
 	}
 }
 
-// Create a new session. Returns a session key, which can be used in future to retrieve the token.
-// The internal session expiry is controlled with the member NewSessionExpiresAfter.
-// The session key is typically sent to the client as a cookie.
+// Create a new session. Returns a session key, which can be used in future to retrieve the
+// token. This is a thin wrapper around LoginWithOptions, kept for backward compatibility; it
+// always produces a SessionLifetimeStandard session expiring after NewSessionExpiresAfter.
 func (x *Central) Login(identity, password string) (sessionkey string, token *Token, e error) {
+	return x.LoginWithOptions(identity, password, LoginOptions{})
+}
+
+// Create a new session, with the expiry and metadata controlled by 'options'. See LoginOptions
+// for StayLoggedIn ("remember me"), MaxAge (a per-login expiry override), and ClientInfo
+// (typically a user-agent and/or IP address, recorded for ListSessionsForIdentity).
+func (x *Central) LoginWithOptions(identity, password string, options LoginOptions) (sessionkey string, token *Token, e error) {
 	token = &Token{}
 	token.Identity = identity
+	if e = x.checkLockout(identity); e != nil {
+		token = nil
+		return "", nil, e
+	}
 	if e = x.authenticator.Authenticate(identity, password); e == nil {
+		x.recordAuthOutcome(identity, true)
 		x.Log.Printf("Login authentication success (%v)", identity)
 		var permit *Permit
 		if permit, e = x.permitDB.GetPermit(identity); e == nil {
-			if x.MaxActiveSessions != 0 {
-				if e = x.sessionDB.InvalidateSessionsForIdentity(identity); e != nil {
-					x.Log.Printf("Invalidate sessions for identity (%v) failed when enforcing MaxActiveSessions (%v)", identity, e)
-					return "", nil, e
-				}
-			}
-			token.Expires = time.Now().Add(x.NewSessionExpiresAfter)
 			token.Permit = *permit
-			sessionkey = generateSessionKey()
-			if e = x.sessionDB.Write(sessionkey, token); e == nil {
-				x.Stats.IncrementGoodLogin(x.Log)
-				x.Log.Printf("Login successful (%v)", identity)
-				return
+			token.ClientInfo = options.ClientInfo
+			if x.mfaRequiredFor(identity) {
+				if sessionkey, token, e = x.issuePendingToken(token, options); e == nil {
+					x.Log.Printf("Login successful, pending MFA (%v)", identity)
+					return
+				}
+				x.Log.Printf("Login issuePendingToken failed (%v) (%v)", identity, e)
+			} else {
+				// MaxActiveSessions is only enforced once the login is fully authenticated -
+				// not here, where only the password has been checked - so that a correct
+				// password alone can never terminate another session ahead of a pending second
+				// factor (see CompleteMFALogin, which enforces it on the MFA path instead).
+				if x.MaxActiveSessions != 0 {
+					if e = x.sessionDB.InvalidateSessionsForIdentity(identity); e != nil {
+						x.Log.Printf("Invalidate sessions for identity (%v) failed when enforcing MaxActiveSessions (%v)", identity, e)
+						return "", nil, e
+					}
+				}
+				token.Stage = TokenStageFull
+				token.IssuedAt = time.Now()
+				token.Lifetime, token.Expires = x.sessionLifetime(options)
+				if sessionkey, e = x.issueSession(token); e == nil {
+					x.Stats.IncrementGoodLogin(x.Log)
+					x.Log.Printf("Login successful (%v)", identity)
+					return
+				}
 			}
 		} else {
 			x.Log.Printf("Login GetPermit failed (%v) (%v)", identity, e)
@@ -387,12 +779,53 @@ func (x *Central) Login(identity, password string) (sessionkey string, token *To
 	} else {
 		x.Stats.IncrementInvalidPasswords(x.Log)
 		x.Log.Printf("Login Authentication failed (%v) (%v)", identity, e)
+		if e == ErrInvalidPassword {
+			x.recordAuthOutcome(identity, false)
+		}
 	}
 	sessionkey = ""
 	token = nil
 	return
 }
 
+// sessionLifetime resolves 'options' into the lifetime class and absolute expiry for a new
+// session: an explicit MaxAge always wins, otherwise StayLoggedIn selects
+// LongSessionExpiresAfter, and the default remains NewSessionExpiresAfter.
+func (x *Central) sessionLifetime(options LoginOptions) (SessionLifetimeClass, time.Time) {
+	switch {
+	case options.MaxAge > 0:
+		lifetime := SessionLifetimeStandard
+		if options.StayLoggedIn {
+			lifetime = SessionLifetimeLong
+		}
+		return lifetime, time.Now().Add(options.MaxAge)
+	case options.StayLoggedIn:
+		return SessionLifetimeLong, time.Now().Add(x.LongSessionExpiresAfter)
+	default:
+		return SessionLifetimeStandard, time.Now().Add(x.NewSessionExpiresAfter)
+	}
+}
+
+// issueSession finalizes 'token' (which must already have Identity, Expires, Permit and Stage
+// set - issueSession does not touch Stage, so a pending token handed to it stays pending) into
+// a session, producing either a signed token or an opaque, database-backed session key,
+// depending on whether EnableSignedSessions has been called.
+func (x *Central) issueSession(token *Token) (sessionkey string, e error) {
+	if x.tokenSigner != nil {
+		if signed, ok := x.sessionDB.(*SignedSessionDB); ok {
+			if token.IdentityVersion, e = signed.Versions.GetIdentityVersion(CanonicalizeIdentity(token.Identity)); e != nil {
+				return "", e
+			}
+		}
+		return x.tokenSigner.Sign(token)
+	}
+	sessionkey = generateSessionKey()
+	if e = x.sessionDB.Write(sessionkey, token); e != nil {
+		return "", e
+	}
+	return sessionkey, nil
+}
+
 // Logout, which erases the session key
 func (x *Central) Logout(sessionkey string) error {
 	x.Stats.IncrementLogout(x.Log)
@@ -457,6 +890,12 @@ func (x *Central) GetRoleGroupDB() RoleGroupDB {
 }
 
 func (x *Central) Close() {
+	if x.lockoutSweepEnd != nil {
+		close(x.lockoutSweepEnd)
+		<-x.lockoutSweepDone
+		x.lockoutSweepEnd = nil
+		x.lockoutSweepDone = nil
+	}
 	if x.Log != nil {
 		x.Log.Printf("Authaus shutting down\n")
 		x.Log = nil
@@ -481,9 +920,20 @@ func (x *Central) Close() {
 		x.roleGroupDB.Close()
 		x.roleGroupDB = nil
 	}
+	if x.lockedUsersDB != nil {
+		x.lockedUsersDB.Close()
+		x.lockedUsersDB = nil
+	}
+	if x.mfaDB != nil {
+		x.mfaDB.Close()
+		x.mfaDB = nil
+	}
 }
 
 func (x *Central) debugEnableSessionDB(enable bool) {
-	// Used for testing the session cache
-	x.sessionDB.(*cachedSessionDB).enableDB = enable
+	// Used for testing the session cache. A no-op once EnableSignedSessions has swapped
+	// sessionDB for a *SignedSessionDB, which has no underlying DB to toggle.
+	if cached, ok := x.sessionDB.(*cachedSessionDB); ok {
+		cached.enableDB = enable
+	}
 }
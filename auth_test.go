@@ -0,0 +1,174 @@
+package authaus
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+type fakeAuthenticator struct {
+	password string
+}
+
+func (f *fakeAuthenticator) Authenticate(identity, password string) error {
+	if password == f.password {
+		return nil
+	}
+	return ErrInvalidPassword
+}
+func (f *fakeAuthenticator) SetPassword(identity, password string) error {
+	f.password = password
+	return nil
+}
+func (f *fakeAuthenticator) CreateIdentity(identity, password string) error { return nil }
+func (f *fakeAuthenticator) GetIdentities() ([]string, error)               { return nil, nil }
+func (f *fakeAuthenticator) Close()                                         {}
+
+type fakePermitDB struct{}
+
+func (f *fakePermitDB) GetPermit(identity string) (*Permit, error)      { return &Permit{}, nil }
+func (f *fakePermitDB) GetPermits() (map[string]*Permit, error)         { return nil, nil }
+func (f *fakePermitDB) SetPermit(identity string, permit *Permit) error { return nil }
+func (f *fakePermitDB) Close()                                          {}
+
+type fakeSessionDB struct {
+	tokens map[string]*Token
+}
+
+func newFakeSessionDB() *fakeSessionDB {
+	return &fakeSessionDB{tokens: map[string]*Token{}}
+}
+func (f *fakeSessionDB) Read(sessionkey string) (*Token, error) {
+	token, ok := f.tokens[sessionkey]
+	if !ok {
+		return nil, ErrInvalidSessionToken
+	}
+	return token, nil
+}
+func (f *fakeSessionDB) Write(sessionkey string, token *Token) error {
+	f.tokens[sessionkey] = token
+	return nil
+}
+func (f *fakeSessionDB) Delete(sessionkey string) error {
+	delete(f.tokens, sessionkey)
+	return nil
+}
+func (f *fakeSessionDB) InvalidateSessionsForIdentity(identity string) error {
+	for key, token := range f.tokens {
+		if token.Identity == identity {
+			delete(f.tokens, key)
+		}
+	}
+	return nil
+}
+func (f *fakeSessionDB) PermitChanged(identity string, permit *Permit) error { return nil }
+func (f *fakeSessionDB) Close()                                              {}
+
+type fakeMFAProvider struct {
+	code string
+}
+
+func (f *fakeMFAProvider) Enroll(identity string) (string, string, error) { return "", "", nil }
+func (f *fakeMFAProvider) Verify(identity, code string) error {
+	if code == f.code {
+		return nil
+	}
+	return ErrInvalidPassword
+}
+func (f *fakeMFAProvider) IsEnrolled(identity string) (bool, error) { return true, nil }
+
+type fakeMFADB struct {
+	required map[string]bool
+}
+
+func (f *fakeMFADB) GetSecret(identity string) (string, error)   { return "secret", nil }
+func (f *fakeMFADB) SetSecret(identity, secret string) error     { return nil }
+func (f *fakeMFADB) IsMFARequired(identity string) (bool, error) { return f.required[identity], nil }
+func (f *fakeMFADB) SetMFARequired(identity string, required bool) error {
+	f.required[identity] = required
+	return nil
+}
+func (f *fakeMFADB) AddBackupCodes(identity string, hashedCodes []string) error { return nil }
+func (f *fakeMFADB) ConsumeBackupCode(identity, code string) (bool, error)      { return false, nil }
+func (f *fakeMFADB) CountRemainingBackupCodes(identity string) (int, error)     { return 0, nil }
+func (f *fakeMFADB) Close()                                                     {}
+
+// newTestCentral builds a Central with fakes wired in directly, bypassing NewCentral's
+// cachedSessionDB/sanitizingAuthenticator wrapping, since these tests only care about the
+// Login/MFA/session-invalidation state machine in auth.go.
+func newTestCentral() (c *Central, auth *fakeAuthenticator, sessionDB *fakeSessionDB) {
+	auth = &fakeAuthenticator{password: "correct horse"}
+	sessionDB = newFakeSessionDB()
+	c = &Central{
+		authenticator:           auth,
+		permitDB:                &fakePermitDB{},
+		sessionDB:               sessionDB,
+		rawSessionDB:            sessionDB,
+		Log:                     log.New(io.Discard, "", 0),
+		NewSessionExpiresAfter:  time.Hour,
+		LongSessionExpiresAfter: 30 * 24 * time.Hour,
+		PreAuthExpiresAfter:     5 * time.Minute,
+	}
+	return c, auth, sessionDB
+}
+
+// Regression test for the MFA bypass where issueSession unconditionally forced
+// token.Stage = TokenStageFull, clobbering the TokenStagePending set by issuePendingToken
+// before the token was ever persisted.
+func TestMFAPendingTokenRejectedByGetTokenFromSession(t *testing.T) {
+	c, _, _ := newTestCentral()
+	c.mfaProvider = &fakeMFAProvider{code: "123456"}
+	c.mfaDB = &fakeMFADB{required: map[string]bool{"alice": true}}
+
+	sessionkey, token, err := c.LoginWithOptions("alice", "correct horse", LoginOptions{})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if token.Stage != TokenStagePending {
+		t.Fatalf("expected a pending token, got Stage=%v", token.Stage)
+	}
+	if _, err := c.GetTokenFromSession(sessionkey); err == nil {
+		t.Fatalf("a pending token must not be accepted as a full session")
+	}
+
+	sessionkey2, token2, err := c.CompleteMFALogin(sessionkey, "123456")
+	if err != nil {
+		t.Fatalf("CompleteMFALogin failed: %v", err)
+	}
+	if token2.Stage != TokenStageFull {
+		t.Fatalf("expected a full token after MFA, got Stage=%v", token2.Stage)
+	}
+	if _, err := c.GetTokenFromSession(sessionkey2); err != nil {
+		t.Fatalf("the full session returned by CompleteMFALogin should be accepted: %v", err)
+	}
+}
+
+// Regression test: a correct password alone must not invalidate a legitimate existing session
+// while a second factor is still outstanding.
+func TestMaxActiveSessionsNotEnforcedBeforeMFACompletes(t *testing.T) {
+	c, _, _ := newTestCentral()
+	c.MaxActiveSessions = 1
+	c.mfaProvider = &fakeMFAProvider{code: "123456"}
+	c.mfaDB = &fakeMFADB{required: map[string]bool{"alice": true}}
+
+	existingKey, err := c.issueSession(&Token{Identity: "alice", Stage: TokenStageFull, Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("seeding existing session failed: %v", err)
+	}
+
+	pendingKey, _, err := c.LoginWithOptions("alice", "correct horse", LoginOptions{})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if _, err := c.GetTokenFromSession(existingKey); err != nil {
+		t.Fatalf("existing session must survive the password check alone: %v", err)
+	}
+
+	if _, _, err := c.CompleteMFALogin(pendingKey, "123456"); err != nil {
+		t.Fatalf("CompleteMFALogin failed: %v", err)
+	}
+	if _, err := c.GetTokenFromSession(existingKey); err == nil {
+		t.Fatalf("existing session should be invalidated once MaxActiveSessions is enforced after MFA completes")
+	}
+}
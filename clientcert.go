@@ -0,0 +1,383 @@
+package authaus
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// revocationHTTPClient fetches CRLs and OCSP responses. It has its own short timeout, separate
+// from any client-facing request timeout, since a slow or unreachable CA must not hang logins.
+var revocationHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ClientCertAuthenticator is an Authenticator that proves identity via an X.509 client
+// certificate, rather than a password. It is not driven through the normal
+// Authenticate(identity, password) call - callers use Central.GetTokenFromClientCert instead.
+// The Authenticate/SetPassword/CreateIdentity/GetIdentities methods exist only to satisfy the
+// Authenticator interface, and delegate to Inner if one was configured, which is how a
+// deployment layers cert-auth (proves identity) on top of an existing LDAP/DB authenticator
+// (provides the permit and continues to own password management).
+type ClientCertAuthenticator struct {
+	// Inner, if non-nil, receives Authenticate/SetPassword/CreateIdentity/GetIdentities calls.
+	Inner Authenticator
+
+	// IdentityTemplate is a text/template string, evaluated against a certIdentityFields, that
+	// produces the Authaus identity for a certificate. For example "{{.Email}}" or "{{.CN}}".
+	IdentityTemplate string
+
+	// RequireExistingIdentity, if true, rejects certificates whose mapped identity has no
+	// entry in the PermitDB, rather than treating it as a once-off identity with an empty
+	// Permit.
+	RequireExistingIdentity bool
+
+	// RevocationRefreshInterval controls how often cached CRL/OCSP responses are refreshed.
+	RevocationRefreshInterval time.Duration
+
+	// Log receives a message whenever a CRL or OCSP fetch fails, or a CRL fails to verify, so
+	// that "revocation checking silently never ran" is visible in the logs rather than hidden
+	// behind the fail-open behaviour of checkCRL/checkOCSP. Defaults to log.Default() if nil.
+	Log *log.Logger
+
+	roots *x509.CertPool
+
+	mu             sync.Mutex
+	revokedSerials map[string]bool // explicit admin revocations, keyed by serial.String()
+	crlCache       map[string]*cachedCRL
+	ocspCache      map[string]*cachedOCSPResponse
+}
+
+type cachedCRL struct {
+	revoked   map[string]bool // serial.String() -> true
+	fetchedAt time.Time
+}
+
+type cachedOCSPResponse struct {
+	good      bool
+	fetchedAt time.Time
+}
+
+type certIdentityFields struct {
+	CN    string
+	Email string
+	URI   string
+}
+
+// NewAuthenticator_ClientCert creates a ClientCertAuthenticator trusting the CA certificates in
+// 'caBundles' (each a PEM-encoded bundle, as you'd load from a file). 'inner', which may be
+// nil, is the Authenticator that owns password management and identity enumeration for hybrid
+// deployments.
+func NewAuthenticator_ClientCert(caBundles [][]byte, identityTemplate string, inner Authenticator) (*ClientCertAuthenticator, error) {
+	c := &ClientCertAuthenticator{
+		Inner:                     inner,
+		IdentityTemplate:          identityTemplate,
+		RevocationRefreshInterval: 1 * time.Hour,
+		Log:                       log.New(os.Stderr, "", log.LstdFlags),
+		roots:                     x509.NewCertPool(),
+		revokedSerials:            map[string]bool{},
+		crlCache:                  map[string]*cachedCRL{},
+		ocspCache:                 map[string]*cachedOCSPResponse{},
+	}
+	for _, bundle := range caBundles {
+		if err := c.RegisterCA(bundle); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// RegisterCA adds the CA certificates in a PEM-encoded bundle to the set of trusted roots. It
+// is safe to call while VerifyCert is handling concurrent requests: x509.CertPool has no
+// internal locking of its own, so rather than mutating the live pool in place, this builds a
+// new pool (cloned from the current one, plus the new CAs) and swaps it in under c.mu.
+func (c *ClientCertAuthenticator) RegisterCA(pemBytes []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := c.roots.Clone()
+	if !next.AppendCertsFromPEM(pemBytes) {
+		return errors.New("ClientCertAuthenticator: no certificates found in CA bundle")
+	}
+	c.roots = next
+	return nil
+}
+
+// RevokeCert marks 'serial' as revoked, regardless of what any CRL or OCSP responder says. Use
+// this for emergency revocation, ahead of the CA's own revocation infrastructure catching up.
+func (c *ClientCertAuthenticator) RevokeCert(serial *big.Int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revokedSerials[serial.String()] = true
+}
+
+// IdentityFromCert evaluates IdentityTemplate against the certificate's Subject CN, and its
+// email/URI Subject Alternative Names, to produce an Authaus identity.
+func (c *ClientCertAuthenticator) IdentityFromCert(cert *x509.Certificate) (string, error) {
+	fields := certIdentityFields{CN: cert.Subject.CommonName}
+	if len(cert.EmailAddresses) > 0 {
+		fields.Email = cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		fields.URI = cert.URIs[0].String()
+	}
+	tmpl, err := template.New("identity").Parse(c.IdentityTemplate)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, &fields); err != nil {
+		return "", err
+	}
+	identity := strings.TrimSpace(out.String())
+	if identity == "" {
+		return "", NewError(ErrIdentityEmpty, "certificate did not yield an identity via IdentityTemplate")
+	}
+	return identity, nil
+}
+
+// VerifyCert checks that 'cert' chains up to a trusted CA and has not been revoked, either by
+// an explicit RevokeCert call, a cached CRL, or a cached OCSP response.
+func (c *ClientCertAuthenticator) VerifyCert(cert *x509.Certificate) error {
+	c.mu.Lock()
+	roots := c.roots
+	c.mu.Unlock()
+	chains, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		return NewError(ErrInvalidPassword, "client certificate does not chain to a trusted CA: "+err.Error())
+	}
+	// The CA that issued 'cert' is needed to check the CRL/OCSP response signatures; it's the
+	// next certificate up the chain that cert.Verify just built for us.
+	var issuer *x509.Certificate
+	if len(chains) > 0 && len(chains[0]) > 1 {
+		issuer = chains[0][1]
+	}
+
+	c.mu.Lock()
+	revoked := c.revokedSerials[cert.SerialNumber.String()]
+	c.mu.Unlock()
+	if revoked {
+		return NewError(ErrInvalidPassword, "client certificate has been revoked")
+	}
+
+	if len(cert.CRLDistributionPoints) > 0 {
+		if c.checkCRL(cert, issuer) {
+			return NewError(ErrInvalidPassword, "client certificate appears on its CRL")
+		}
+	}
+	if len(cert.OCSPServer) > 0 {
+		good, checked := c.checkOCSP(cert, issuer)
+		if checked && !good {
+			return NewError(ErrInvalidPassword, "client certificate failed OCSP revocation check")
+		}
+	}
+	return nil
+}
+
+// checkCRL reports whether 'cert' is present on its (cached) CRL. On a fetch failure, or a CRL
+// that fails to verify against 'issuer', the previously cached CRL is used if there is one, and
+// otherwise the certificate is treated as "not revoked", since a CA being temporarily
+// unreachable should not be an outage for every client holding a certificate from it; RevokeCert
+// remains available for urgent cases. Every such failure is logged, so a revocation check that
+// silently never ran is at least visible in the logs.
+func (c *ClientCertAuthenticator) checkCRL(cert *x509.Certificate, issuer *x509.Certificate) bool {
+	url := cert.CRLDistributionPoints[0]
+	c.mu.Lock()
+	cached, ok := c.crlCache[url]
+	c.mu.Unlock()
+	if !ok || time.Since(cached.fetchedAt) > c.RevocationRefreshInterval {
+		crl, err := fetchCRL(url)
+		if err == nil && issuer != nil {
+			err = issuer.CheckCRLSignature(crl)
+		}
+		if err != nil {
+			c.logRevocationCheckFailed("CRL", url, err)
+			if ok {
+				return cached.revoked[cert.SerialNumber.String()]
+			}
+			return false
+		}
+		revoked := map[string]bool{}
+		for _, rc := range crl.TBSCertList.RevokedCertificates {
+			revoked[rc.SerialNumber.String()] = true
+		}
+		cached = &cachedCRL{revoked: revoked, fetchedAt: time.Now()}
+		c.mu.Lock()
+		c.crlCache[url] = cached
+		c.mu.Unlock()
+	}
+	return cached.revoked[cert.SerialNumber.String()]
+}
+
+func (c *ClientCertAuthenticator) checkOCSP(cert *x509.Certificate, issuer *x509.Certificate) (good bool, checked bool) {
+	url := cert.OCSPServer[0]
+	key := url + cert.SerialNumber.String()
+	c.mu.Lock()
+	cached, ok := c.ocspCache[key]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) <= c.RevocationRefreshInterval {
+		return cached.good, true
+	}
+	resp, err := fetchOCSP(url, cert, issuer)
+	if err != nil {
+		c.logRevocationCheckFailed("OCSP", url, err)
+		if ok {
+			return cached.good, true
+		}
+		return false, false
+	}
+	good = resp.Status == ocsp.Good
+	c.mu.Lock()
+	c.ocspCache[key] = &cachedOCSPResponse{good: good, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return good, true
+}
+
+func (c *ClientCertAuthenticator) logRevocationCheckFailed(kind, url string, err error) {
+	logger := c.Log
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("Client cert %v check against %v failed, using the cached result if there is one, otherwise treating certificate as not revoked (%v)", kind, url, err)
+}
+
+func (c *ClientCertAuthenticator) Authenticate(identity, password string) error {
+	if c.Inner != nil {
+		return c.Inner.Authenticate(identity, password)
+	}
+	return ErrUnsupported
+}
+
+func (c *ClientCertAuthenticator) SetPassword(identity, password string) error {
+	if c.Inner != nil {
+		return c.Inner.SetPassword(identity, password)
+	}
+	return ErrUnsupported
+}
+
+func (c *ClientCertAuthenticator) CreateIdentity(identity, password string) error {
+	if c.Inner != nil {
+		return c.Inner.CreateIdentity(identity, password)
+	}
+	return ErrUnsupported
+}
+
+func (c *ClientCertAuthenticator) GetIdentities() ([]string, error) {
+	if c.Inner != nil {
+		return c.Inner.GetIdentities()
+	}
+	return nil, ErrUnsupported
+}
+
+func (c *ClientCertAuthenticator) Close() {
+	if c.Inner != nil {
+		c.Inner.Close()
+	}
+}
+
+// clientCertAuthenticator returns the ClientCertAuthenticator behind x.authenticator, or nil if
+// cert-based auth was not configured.
+func (x *Central) clientCertAuthenticator() *ClientCertAuthenticator {
+	sa, ok := x.authenticator.(*sanitizingAuthenticator)
+	if !ok {
+		return nil
+	}
+	ca, ok := sa.backend.(*ClientCertAuthenticator)
+	if !ok {
+		return nil
+	}
+	return ca
+}
+
+// GetTokenFromClientCert performs a once-off authentication using an X.509 client certificate,
+// in the same style as GetTokenFromIdentityPassword: the returned Token carries a very-far-
+// future expiry, since it is the caller's responsibility to re-verify the certificate on
+// whatever schedule makes sense for them, rather than this being a cookie-backed session.
+func (x *Central) GetTokenFromClientCert(cert *x509.Certificate) (*Token, error) {
+	certAuth := x.clientCertAuthenticator()
+	if certAuth == nil {
+		return nil, ErrUnsupported
+	}
+	if err := certAuth.VerifyCert(cert); err != nil {
+		x.Log.Printf("Client cert verification failed (%v)", err)
+		return nil, err
+	}
+	identity, err := certAuth.IdentityFromCert(cert)
+	if err != nil {
+		return nil, err
+	}
+	permit, err := x.permitDB.GetPermit(identity)
+	if err != nil {
+		if certAuth.RequireExistingIdentity {
+			x.Log.Printf("Client cert auth GetPermit failed (%v) (%v)", identity, err)
+			return nil, err
+		}
+		permit = &Permit{}
+	}
+	t := &Token{Identity: identity, Expires: veryFarFuture, Permit: *permit}
+	x.Stats.IncrementGoodOnceOffAuth(x.Log)
+	x.Log.Printf("Client cert auth successful (%v)", identity)
+	return t, nil
+}
+
+// fetchCRL retrieves and parses the CRL published at 'url'. Only HTTP(S) distribution points
+// are supported; an LDAP distribution point is returned as an error, same as any other fetch
+// failure, and is handled the same fail-open way by checkCRL.
+func fetchCRL(url string) (*pkix.CertificateList, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("CRL distribution point %v uses an unsupported scheme", url)
+	}
+	resp, err := revocationHTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching CRL from %v: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching CRL from %v: HTTP %v", url, resp.StatusCode)
+	}
+	der, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading CRL from %v: %w", url, err)
+	}
+	return x509.ParseCRL(der)
+}
+
+// fetchOCSP builds an OCSP request for 'cert' (signed by 'issuer'), POSTs it to the responder
+// at 'url', and parses + verifies the response against 'issuer'.
+func fetchOCSP(url string, cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	if issuer == nil {
+		return nil, fmt.Errorf("OCSP lookup against %v requires the issuing certificate, which was not found in the verified chain", url)
+	}
+	reqBytes, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building OCSP request for %v: %w", url, err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("building OCSP HTTP request for %v: %w", url, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpResp, err := revocationHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("fetching OCSP response from %v: %w", url, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching OCSP response from %v: HTTP %v", url, httpResp.StatusCode)
+	}
+	respBytes, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading OCSP response from %v: %w", url, err)
+	}
+	return ocsp.ParseResponseForCert(respBytes, cert, issuer)
+}
@@ -0,0 +1,151 @@
+package authaus
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mustGenerateCA(t *testing.T) (*x509.Certificate, *rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return cert, key, pemBytes
+}
+
+func mustGenerateLeaf(t *testing.T, ca *x509.Certificate, caKey *rsa.PrivateKey, serial int64, crlURL string) *x509.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "alice"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		CRLDistributionPoints: []string{crlURL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIdentityFromCert(t *testing.T) {
+	auth, err := NewAuthenticator_ClientCert(nil, "{{.CN}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	identity, err := auth.IdentityFromCert(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "alice" {
+		t.Fatalf("got %q", identity)
+	}
+}
+
+// Regression test for the fail-open CRL stub: fetchCRL/checkCRL used to always return "not
+// revoked" because the fetch itself was a permanent stub. With a real CRL served over HTTP, a
+// revoked certificate must now be rejected.
+func TestVerifyCertRejectsRevokedCertFromCRL(t *testing.T) {
+	ca, caKey, caPEM := mustGenerateCA(t)
+
+	var crlURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked := []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(2), RevocationTime: time.Now()},
+		}
+		der, err := x509.CreateCRL(rand.Reader, ca, caKey, revoked, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(der)
+	}))
+	defer server.Close()
+	crlURL = server.URL
+
+	revokedLeaf := mustGenerateLeaf(t, ca, caKey, 2, crlURL)
+	goodLeaf := mustGenerateLeaf(t, ca, caKey, 3, crlURL)
+
+	auth, err := NewAuthenticator_ClientCert([][]byte{caPEM}, "{{.CN}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.VerifyCert(revokedLeaf); err == nil {
+		t.Fatalf("expected a revoked certificate to fail verification")
+	}
+	if err := auth.VerifyCert(goodLeaf); err != nil {
+		t.Fatalf("expected a non-revoked certificate to verify: %v", err)
+	}
+}
+
+// Regression test: x509.CertPool has no locking of its own, so RegisterCA running concurrently
+// with VerifyCert used to be a data race on the shared *x509.CertPool - caught here by -race.
+func TestRegisterCAConcurrentWithVerifyCert(t *testing.T) {
+	ca, _, caPEM := mustGenerateCA(t)
+	auth, err := NewAuthenticator_ClientCert([][]byte{caPEM}, "{{.CN}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extraPEMs := make([][]byte, 50)
+	for i := range extraPEMs {
+		_, _, extraPEMs[i] = mustGenerateCA(t)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, extraPEM := range extraPEMs {
+			if err := auth.RegisterCA(extraPEM); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	leaf := &x509.Certificate{Raw: ca.Raw}
+	for i := 0; i < 50; i++ {
+		// Errors are expected here (leaf isn't a valid client cert) - only a crash/race is a
+		// failure, which is what this test exists to catch under -race.
+		_ = auth.VerifyCert(leaf)
+	}
+	<-done
+}
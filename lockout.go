@@ -0,0 +1,259 @@
+package authaus
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LockoutPolicy configures per-identity brute-force lockout, enforced by
+// Central.GetTokenFromIdentityPassword and Central.Login once EnableLockoutPolicy has been
+// called.
+type LockoutPolicy struct {
+	// MaxAttempts is the number of failed attempts, within Window, that triggers a lockout.
+	MaxAttempts int
+	// Window is the period over which failed attempts are counted, starting at the first
+	// failure of a run. Once that first failure is older than Window, the next failure starts a
+	// new run with a count of 1. This is a fixed window, not a sliding one: earlier failures that
+	// still fall within Window of that moment are not counted.
+	Window time.Duration
+	// LockoutDuration is how long an identity stays locked out after crossing MaxAttempts.
+	LockoutDuration time.Duration
+	// PermanentLockThreshold, if non-zero, is the number of separate lockouts (not failed
+	// attempts) after which an identity is locked permanently, requiring UnlockIdentity.
+	PermanentLockThreshold int
+	// SweepInterval controls how often expired lockout records are purged. Defaults to 5
+	// minutes if zero.
+	SweepInterval time.Duration
+}
+
+// LockedIdentity describes an identity that is currently locked out.
+type LockedIdentity struct {
+	Identity  string
+	LockedAt  time.Time
+	UntilTime time.Time // zero if Permanent is true
+	Permanent bool
+}
+
+// LockedUsersDB tracks failed-authentication counters and lockout state per identity. The SQL
+// implementation (NewLockedUsersDB_SQL) shares its underlying database with PermitDB, and
+// implements the counter increment as a single atomic upsert, so that multiple Central
+// instances pointed at the same database stay consistent without a read-modify-write race.
+type LockedUsersDB interface {
+	// RecordFailure increments the failure counter for 'identity' and, if 'policy' says the
+	// identity should now be locked out, records that lockout. It returns the lockout that is
+	// now in effect, or nil if the identity is not (yet) locked out.
+	RecordFailure(identity string, policy LockoutPolicy) (*LockedIdentity, error)
+
+	// RecordSuccess resets the failure counter for 'identity'. It does not clear an existing
+	// lockout - a successful password check during a lockout window should not be possible
+	// in the first place, since GetLockout is consulted first.
+	RecordSuccess(identity string) error
+
+	// GetLockout returns the current lockout for 'identity', or nil if it is not locked out.
+	GetLockout(identity string) (*LockedIdentity, error)
+
+	// Unlock clears any lockout and resets the failure counter for 'identity'.
+	Unlock(identity string) error
+
+	// ListLocked returns every identity that is currently locked out.
+	ListLocked() ([]LockedIdentity, error)
+
+	// PurgeExpired deletes records that are not permanently locked, whose lockout (if any) has
+	// passed, and whose failure counter is zero. Records with a non-zero failure counter are
+	// kept, however old those failures are.
+	PurgeExpired() error
+
+	Close()
+}
+
+// checkLockout returns ErrIdentityLocked if 'identity' is currently locked out. It is a no-op
+// (returns nil) if lockout tracking has not been enabled.
+func (x *Central) checkLockout(identity string) error {
+	if x.lockedUsersDB == nil {
+		return nil
+	}
+	lock, err := x.lockedUsersDB.GetLockout(CanonicalizeIdentity(identity))
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return nil
+	}
+	if lock.Permanent {
+		return NewError(ErrIdentityLocked, fmt.Sprintf("%v is permanently locked; an administrator must unlock it", identity))
+	}
+	return NewError(ErrIdentityLocked, fmt.Sprintf("%v is locked until %v", identity, lock.UntilTime.Format(time.RFC3339)))
+}
+
+// recordAuthOutcome updates the failure counter for 'identity' after an authentication
+// attempt. It is a no-op if lockout tracking has not been enabled.
+func (x *Central) recordAuthOutcome(identity string, success bool) {
+	if x.lockedUsersDB == nil {
+		return
+	}
+	canon := CanonicalizeIdentity(identity)
+	if success {
+		if err := x.lockedUsersDB.RecordSuccess(canon); err != nil {
+			x.Log.Printf("RecordSuccess failed (%v) (%v)", identity, err)
+		}
+		return
+	}
+	lock, err := x.lockedUsersDB.RecordFailure(canon, x.LockoutPolicy)
+	if err != nil {
+		x.Log.Printf("RecordFailure failed (%v) (%v)", identity, err)
+		return
+	}
+	if lock != nil {
+		x.Log.Printf("Identity locked out (%v) until (%v) permanent(%v)", identity, lock.UntilTime, lock.Permanent)
+	}
+}
+
+// lockedUsersDB_SQL is the SQL-backed LockedUsersDB. It expects a table of the form:
+//
+//	CREATE TABLE LockedUser (
+//	    Identity     VARCHAR(256) PRIMARY KEY,
+//	    FailCount    INT NOT NULL DEFAULT 0,
+//	    FirstFailure TIMESTAMP NOT NULL,
+//	    LastFailure  TIMESTAMP NOT NULL,
+//	    LockedUntil  TIMESTAMP NULL,
+//	    LockCount    INT NOT NULL DEFAULT 0,
+//	    Permanent    BOOLEAN NOT NULL DEFAULT FALSE
+//	)
+type lockedUsersDB_SQL struct {
+	db *sql.DB
+}
+
+// NewLockedUsersDB_SQL creates a LockedUsersDB backed by the given SQL database - typically the
+// same database used for PermitDB.
+func NewLockedUsersDB_SQL(config *ConfigDB) (LockedUsersDB, error) {
+	db, err := sqlOpenConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &lockedUsersDB_SQL{db: db}, nil
+}
+
+func (x *lockedUsersDB_SQL) RecordFailure(identity string, policy LockoutPolicy) (*LockedIdentity, error) {
+	now := time.Now()
+	windowStart := now.Add(-policy.Window)
+	until := now.Add(policy.LockoutDuration)
+
+	// A single upsert, with the lockout transition folded into the same CASE expressions that
+	// compute the new FailCount, so that crossing MaxAttempts and escalating LockCount/Permanent
+	// happen atomically with the increment. Nothing here is decided in Go between two round
+	// trips - two concurrent failures that both cross MaxAttempts in the same window serialize
+	// on this row and each sees the other's update, so neither LockCount increment is lost.
+	row := x.db.QueryRow(`
+		INSERT INTO "LockedUser" ("Identity", "FailCount", "FirstFailure", "LastFailure", "LockedUntil", "LockCount", "Permanent")
+		VALUES ($1, 1, $2, $2, NULL, 0, FALSE)
+		ON CONFLICT ("Identity") DO UPDATE SET
+			"FirstFailure" = CASE WHEN "LockedUser"."FirstFailure" < $3 THEN $2 ELSE "LockedUser"."FirstFailure" END,
+			"LastFailure" = $2,
+			"FailCount" = CASE
+				WHEN "LockedUser"."Permanent" THEN "LockedUser"."FailCount"
+				WHEN (CASE WHEN "LockedUser"."FirstFailure" < $3 THEN 1 ELSE "LockedUser"."FailCount" + 1 END) >= $4 THEN 0
+				ELSE (CASE WHEN "LockedUser"."FirstFailure" < $3 THEN 1 ELSE "LockedUser"."FailCount" + 1 END)
+			END,
+			"LockCount" = CASE
+				WHEN "LockedUser"."Permanent" THEN "LockedUser"."LockCount"
+				WHEN (CASE WHEN "LockedUser"."FirstFailure" < $3 THEN 1 ELSE "LockedUser"."FailCount" + 1 END) >= $4 THEN "LockedUser"."LockCount" + 1
+				ELSE "LockedUser"."LockCount"
+			END,
+			"LockedUntil" = CASE
+				WHEN "LockedUser"."Permanent" THEN "LockedUser"."LockedUntil"
+				WHEN (CASE WHEN "LockedUser"."FirstFailure" < $3 THEN 1 ELSE "LockedUser"."FailCount" + 1 END) >= $4 THEN $5
+				ELSE "LockedUser"."LockedUntil"
+			END,
+			"Permanent" = CASE
+				WHEN "LockedUser"."Permanent" THEN TRUE
+				WHEN (CASE WHEN "LockedUser"."FirstFailure" < $3 THEN 1 ELSE "LockedUser"."FailCount" + 1 END) >= $4
+					AND $6 > 0 AND ("LockedUser"."LockCount" + 1) >= $6 THEN TRUE
+				ELSE FALSE
+			END
+		RETURNING "FailCount", "LockCount", "Permanent", "LockedUntil"`,
+		identity, now, windowStart, policy.MaxAttempts, until, policy.PermanentLockThreshold)
+
+	var failCount, lockCount int
+	var permanent bool
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&failCount, &lockCount, &permanent, &lockedUntil); err != nil {
+		return nil, err
+	}
+
+	if permanent {
+		return &LockedIdentity{Identity: identity, Permanent: true}, nil
+	}
+	if !lockedUntil.Valid || lockedUntil.Time.Before(now) {
+		return nil, nil
+	}
+	return &LockedIdentity{Identity: identity, LockedAt: now, UntilTime: lockedUntil.Time, Permanent: false}, nil
+}
+
+func (x *lockedUsersDB_SQL) RecordSuccess(identity string) error {
+	_, err := x.db.Exec(`UPDATE "LockedUser" SET "FailCount" = 0 WHERE "Identity" = $1`, identity)
+	return err
+}
+
+func (x *lockedUsersDB_SQL) GetLockout(identity string) (*LockedIdentity, error) {
+	var until sql.NullTime
+	var permanent bool
+	row := x.db.QueryRow(`SELECT "LockedUntil", "Permanent" FROM "LockedUser" WHERE "Identity" = $1`, identity)
+	if err := row.Scan(&until, &permanent); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if permanent {
+		return &LockedIdentity{Identity: identity, Permanent: true}, nil
+	}
+	if !until.Valid || time.Now().After(until.Time) {
+		return nil, nil
+	}
+	return &LockedIdentity{Identity: identity, UntilTime: until.Time}, nil
+}
+
+func (x *lockedUsersDB_SQL) Unlock(identity string) error {
+	_, err := x.db.Exec(`
+		UPDATE "LockedUser" SET "FailCount" = 0, "LockedUntil" = NULL, "Permanent" = FALSE
+		WHERE "Identity" = $1`, identity)
+	return err
+}
+
+func (x *lockedUsersDB_SQL) ListLocked() ([]LockedIdentity, error) {
+	rows, err := x.db.Query(`
+		SELECT "Identity", "LockedUntil", "Permanent" FROM "LockedUser"
+		WHERE "Permanent" = TRUE OR "LockedUntil" > $1`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locked := []LockedIdentity{}
+	for rows.Next() {
+		var identity string
+		var until sql.NullTime
+		var permanent bool
+		if err := rows.Scan(&identity, &until, &permanent); err != nil {
+			return nil, err
+		}
+		li := LockedIdentity{Identity: identity, Permanent: permanent}
+		if until.Valid && !permanent {
+			li.UntilTime = until.Time
+		}
+		locked = append(locked, li)
+	}
+	return locked, rows.Err()
+}
+
+func (x *lockedUsersDB_SQL) PurgeExpired() error {
+	_, err := x.db.Exec(`
+		DELETE FROM "LockedUser"
+		WHERE "Permanent" = FALSE AND ("LockedUntil" IS NULL OR "LockedUntil" < $1) AND "FailCount" = 0`, time.Now())
+	return err
+}
+
+func (x *lockedUsersDB_SQL) Close() {
+	x.db.Close()
+}
@@ -0,0 +1,86 @@
+package authaus
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// fakeLockedUsersDB is a simple, non-atomic stand-in for lockedUsersDB_SQL, good enough to
+// exercise Central.checkLockout/recordAuthOutcome. It does not exercise the atomic single-upsert
+// SQL in lockedUsersDB_SQL.RecordFailure itself - that needs integration coverage against a real
+// Postgres instance, which isn't available here.
+type fakeLockedUsersDB struct {
+	lockouts map[string]*LockedIdentity
+	failures map[string]int
+}
+
+func newFakeLockedUsersDB() *fakeLockedUsersDB {
+	return &fakeLockedUsersDB{lockouts: map[string]*LockedIdentity{}, failures: map[string]int{}}
+}
+func (f *fakeLockedUsersDB) RecordFailure(identity string, policy LockoutPolicy) (*LockedIdentity, error) {
+	f.failures[identity]++
+	if f.failures[identity] >= policy.MaxAttempts {
+		lock := &LockedIdentity{Identity: identity, LockedAt: time.Now(), UntilTime: time.Now().Add(policy.LockoutDuration)}
+		f.lockouts[identity] = lock
+		f.failures[identity] = 0
+		return lock, nil
+	}
+	return nil, nil
+}
+func (f *fakeLockedUsersDB) RecordSuccess(identity string) error {
+	f.failures[identity] = 0
+	return nil
+}
+func (f *fakeLockedUsersDB) GetLockout(identity string) (*LockedIdentity, error) {
+	return f.lockouts[identity], nil
+}
+func (f *fakeLockedUsersDB) Unlock(identity string) error {
+	delete(f.lockouts, identity)
+	f.failures[identity] = 0
+	return nil
+}
+func (f *fakeLockedUsersDB) ListLocked() ([]LockedIdentity, error) {
+	locked := []LockedIdentity{}
+	for _, l := range f.lockouts {
+		locked = append(locked, *l)
+	}
+	return locked, nil
+}
+func (f *fakeLockedUsersDB) PurgeExpired() error { return nil }
+func (f *fakeLockedUsersDB) Close()              {}
+
+func TestCheckLockoutBlocksAfterThreshold(t *testing.T) {
+	db := newFakeLockedUsersDB()
+	c := &Central{
+		lockedUsersDB: db,
+		LockoutPolicy: LockoutPolicy{MaxAttempts: 3, LockoutDuration: time.Hour},
+		Log:           log.New(io.Discard, "", 0),
+	}
+
+	for i := 0; i < 2; i++ {
+		c.recordAuthOutcome("alice", false)
+		if err := c.checkLockout("alice"); err != nil {
+			t.Fatalf("should not be locked out yet (attempt %d): %v", i, err)
+		}
+	}
+	c.recordAuthOutcome("alice", false)
+	if err := c.checkLockout("alice"); err == nil {
+		t.Fatalf("expected identity to be locked out after crossing MaxAttempts")
+	}
+}
+
+func TestRecordAuthOutcomeSuccessResetsFailures(t *testing.T) {
+	db := newFakeLockedUsersDB()
+	c := &Central{
+		lockedUsersDB: db,
+		LockoutPolicy: LockoutPolicy{MaxAttempts: 3, LockoutDuration: time.Hour},
+		Log:           log.New(io.Discard, "", 0),
+	}
+	c.recordAuthOutcome("alice", false)
+	c.recordAuthOutcome("alice", true)
+	if db.failures["alice"] != 0 {
+		t.Fatalf("expected a successful outcome to reset the failure counter")
+	}
+}
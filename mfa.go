@@ -0,0 +1,292 @@
+package authaus
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// MFAProvider is a second authentication factor layered on top of password authentication by
+// Central.Login. The initial implementation is TOTPProvider (RFC 6238).
+type MFAProvider interface {
+	// Enroll generates a new secret for 'identity' and returns it, along with an otpauth://
+	// URL suitable for rendering as a QR code in an authenticator app.
+	Enroll(identity string) (secret, qrURL string, err error)
+
+	// Verify checks 'code' - a TOTP code, or one of the identity's backup codes - against the
+	// identity's enrolled secret.
+	Verify(identity, code string) error
+
+	// IsEnrolled reports whether 'identity' has completed enrollment.
+	IsEnrolled(identity string) (bool, error)
+}
+
+// MFADB is the storage interface behind TOTPProvider, plus the administrative bits (the
+// MFA-required flag and backup codes) that don't belong in the MFAProvider interface itself,
+// since they're about policy rather than the mechanics of one particular second factor.
+type MFADB interface {
+	GetSecret(identity string) (secret string, err error) // secret == "" if not enrolled
+	SetSecret(identity, secret string) error
+
+	IsMFARequired(identity string) (bool, error)
+	SetMFARequired(identity string, required bool) error
+
+	// AddBackupCodes replaces any existing backup codes for 'identity' with 'hashedCodes'
+	// (each produced by HashBackupCode).
+	AddBackupCodes(identity string, hashedCodes []string) error
+	// ConsumeBackupCode checks 'code' against the identity's remaining backup codes, deleting
+	// it if it matches, and reports whether it matched.
+	ConsumeBackupCode(identity, code string) (bool, error)
+	CountRemainingBackupCodes(identity string) (int, error)
+
+	Close()
+}
+
+// TOTPProvider implements MFAProvider using RFC 6238 time-based one-time passwords.
+type TOTPProvider struct {
+	DB     MFADB
+	Issuer string
+	Digits int           // defaults to 6
+	Period time.Duration // defaults to 30s
+	Skew   int           // number of periods of clock drift to tolerate on either side; NewTOTPProvider sets 1, zero tolerates none
+}
+
+// NewTOTPProvider creates a TOTPProvider with the usual Google-Authenticator-compatible
+// defaults: 6 digits, a 30 second period, and +-1 period of clock skew tolerance.
+func NewTOTPProvider(db MFADB, issuer string) *TOTPProvider {
+	return &TOTPProvider{
+		DB:     db,
+		Issuer: issuer,
+		Digits: 6,
+		Period: 30 * time.Second,
+		Skew:   1,
+	}
+}
+
+func (t *TOTPProvider) Enroll(identity string) (secret, qrURL string, err error) {
+	raw := make([]byte, 20)
+	if _, err = rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	if err = t.DB.SetSecret(CanonicalizeIdentity(identity), secret); err != nil {
+		return "", "", err
+	}
+	qrURL = fmt.Sprintf("otpauth://totp/%v:%v?secret=%v&issuer=%v&digits=%v&period=%v",
+		url.QueryEscape(t.Issuer), url.QueryEscape(identity), secret, url.QueryEscape(t.Issuer), t.digits(), int(t.period().Seconds()))
+	return secret, qrURL, nil
+}
+
+func (t *TOTPProvider) IsEnrolled(identity string) (bool, error) {
+	secret, err := t.DB.GetSecret(CanonicalizeIdentity(identity))
+	if err != nil {
+		return false, err
+	}
+	return secret != "", nil
+}
+
+func (t *TOTPProvider) Verify(identity, code string) error {
+	canon := CanonicalizeIdentity(identity)
+	secret, err := t.DB.GetSecret(canon)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return NewError(ErrIdentityAuthNotFound, "identity is not enrolled for MFA")
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for skew := -t.Skew; skew <= t.Skew; skew++ {
+		counter := uint64(now.Add(time.Duration(skew)*t.period()).UnixNano()) / uint64(t.period())
+		if subtle.ConstantTimeCompare([]byte(code), []byte(totpCode(key, counter, t.digits()))) == 1 {
+			return nil
+		}
+	}
+	if ok, err := t.DB.ConsumeBackupCode(canon, HashBackupCode(code)); err == nil && ok {
+		return nil
+	}
+	return NewError(ErrInvalidPassword, "invalid MFA code")
+}
+
+func (t *TOTPProvider) digits() int {
+	if t.Digits == 0 {
+		return 6
+	}
+	return t.Digits
+}
+
+func (t *TOTPProvider) period() time.Duration {
+	if t.Period == 0 {
+		return 30 * time.Second
+	}
+	return t.Period
+}
+
+// totpCode computes the RFC 6238 TOTP value for 'counter' (the number of whole periods since
+// the Unix epoch), using the RFC 4226 HOTP algorithm as its base.
+func totpCode(key []byte, counter uint64, digits int) string {
+	counterBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterBytes, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counterBytes)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, truncated%mod)
+}
+
+// GenerateBackupCodes creates 'count' new single-use recovery codes for 'identity', replacing
+// any existing ones, and returns the plaintext codes - these must be shown to the user once,
+// since only their hash is stored.
+func (x *Central) GenerateBackupCodes(identity string, count int) ([]string, error) {
+	if x.mfaDB == nil {
+		return nil, ErrUnsupported
+	}
+	codes := make([]string, count)
+	hashed := make([]string, count)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		codes[i] = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+		hashed[i] = HashBackupCode(codes[i])
+	}
+	if err := x.mfaDB.AddBackupCodes(CanonicalizeIdentity(identity), hashed); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CountRemainingBackupCodes returns how many of an identity's backup codes have not yet been
+// consumed.
+func (x *Central) CountRemainingBackupCodes(identity string) (int, error) {
+	if x.mfaDB == nil {
+		return 0, ErrUnsupported
+	}
+	return x.mfaDB.CountRemainingBackupCodes(CanonicalizeIdentity(identity))
+}
+
+// HashBackupCode produces the value that MFADB stores and compares backup codes by; codes are
+// never stored or logged in plaintext.
+func HashBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return fmt.Sprintf("%x", sum)
+}
+
+// mfaDB_SQL is the SQL-backed MFADB. It expects tables of the form:
+//
+//	CREATE TABLE MFASecret (Identity VARCHAR(256) PRIMARY KEY, Secret VARCHAR(64) NOT NULL, Required BOOLEAN NOT NULL DEFAULT FALSE)
+//	CREATE TABLE MFABackupCode (Identity VARCHAR(256) NOT NULL, CodeHash VARCHAR(64) NOT NULL, PRIMARY KEY (Identity, CodeHash))
+type mfaDB_SQL struct {
+	db *sql.DB
+}
+
+// NewMFADB_SQL creates an MFADB backed by the given SQL database - typically the same database
+// used for PermitDB.
+func NewMFADB_SQL(config *ConfigDB) (MFADB, error) {
+	db, err := sqlOpenConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &mfaDB_SQL{db: db}, nil
+}
+
+func (x *mfaDB_SQL) GetSecret(identity string) (string, error) {
+	var secret string
+	row := x.db.QueryRow(`SELECT "Secret" FROM "MFASecret" WHERE "Identity" = $1`, identity)
+	if err := row.Scan(&secret); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return secret, nil
+}
+
+func (x *mfaDB_SQL) SetSecret(identity, secret string) error {
+	_, err := x.db.Exec(`
+		INSERT INTO "MFASecret" ("Identity", "Secret", "Required") VALUES ($1, $2, FALSE)
+		ON CONFLICT ("Identity") DO UPDATE SET "Secret" = $2`, identity, secret)
+	return err
+}
+
+func (x *mfaDB_SQL) IsMFARequired(identity string) (bool, error) {
+	var required bool
+	row := x.db.QueryRow(`SELECT "Required" FROM "MFASecret" WHERE "Identity" = $1`, identity)
+	if err := row.Scan(&required); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return required, nil
+}
+
+func (x *mfaDB_SQL) SetMFARequired(identity string, required bool) error {
+	_, err := x.db.Exec(`
+		INSERT INTO "MFASecret" ("Identity", "Secret", "Required") VALUES ($1, '', $2)
+		ON CONFLICT ("Identity") DO UPDATE SET "Required" = $2`, identity, required)
+	return err
+}
+
+func (x *mfaDB_SQL) AddBackupCodes(identity string, hashedCodes []string) error {
+	tx, err := x.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM "MFABackupCode" WHERE "Identity" = $1`, identity); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hashed := range hashedCodes {
+		if _, err = tx.Exec(`INSERT INTO "MFABackupCode" ("Identity", "CodeHash") VALUES ($1, $2)`, identity, hashed); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (x *mfaDB_SQL) ConsumeBackupCode(identity, hashedCode string) (bool, error) {
+	result, err := x.db.Exec(`DELETE FROM "MFABackupCode" WHERE "Identity" = $1 AND "CodeHash" = $2`, identity, hashedCode)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (x *mfaDB_SQL) CountRemainingBackupCodes(identity string) (int, error) {
+	var count int
+	row := x.db.QueryRow(`SELECT COUNT(*) FROM "MFABackupCode" WHERE "Identity" = $1`, identity)
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (x *mfaDB_SQL) Close() {
+	x.db.Close()
+}
@@ -0,0 +1,141 @@
+package authaus
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// fakeTOTPStore is a minimal in-memory MFADB, just enough to drive TOTPProvider directly -
+// distinct from auth_test.go's fakeMFADB, which is a stub used only to exercise Central's
+// login/MFA state machine and doesn't implement backup codes for real.
+type fakeTOTPStore struct {
+	secrets     map[string]string
+	required    map[string]bool
+	backupCodes map[string]map[string]bool
+}
+
+func newFakeTOTPStore() *fakeTOTPStore {
+	return &fakeTOTPStore{
+		secrets:     map[string]string{},
+		required:    map[string]bool{},
+		backupCodes: map[string]map[string]bool{},
+	}
+}
+func (f *fakeTOTPStore) GetSecret(identity string) (string, error) { return f.secrets[identity], nil }
+func (f *fakeTOTPStore) SetSecret(identity, secret string) error {
+	f.secrets[identity] = secret
+	return nil
+}
+func (f *fakeTOTPStore) IsMFARequired(identity string) (bool, error) {
+	return f.required[identity], nil
+}
+func (f *fakeTOTPStore) SetMFARequired(identity string, required bool) error {
+	f.required[identity] = required
+	return nil
+}
+func (f *fakeTOTPStore) AddBackupCodes(identity string, hashedCodes []string) error {
+	codes := map[string]bool{}
+	for _, c := range hashedCodes {
+		codes[c] = true
+	}
+	f.backupCodes[identity] = codes
+	return nil
+}
+func (f *fakeTOTPStore) ConsumeBackupCode(identity, code string) (bool, error) {
+	codes := f.backupCodes[identity]
+	if codes == nil || !codes[code] {
+		return false, nil
+	}
+	delete(codes, code)
+	return true, nil
+}
+func (f *fakeTOTPStore) CountRemainingBackupCodes(identity string) (int, error) {
+	return len(f.backupCodes[identity]), nil
+}
+func (f *fakeTOTPStore) Close() {}
+
+// TestTOTPCodeAgainstRFC6238Vectors checks totpCode against the worked examples from RFC 6238
+// Appendix B (the SHA-1, 8-digit, 30-second-period case, secret "12345678901234567890").
+func TestTOTPCodeAgainstRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		counter uint64
+		want    string
+	}{
+		{1, "94287082"},         // T = 59
+		{37037036, "07081804"},  // T = 1111111109
+		{37037037, "14050471"},  // T = 1111111111
+		{41152263, "89005924"},  // T = 1234567890
+		{66666666, "69279037"},  // T = 2000000000
+		{666666666, "65353130"}, // T = 20000000000
+	}
+
+	for _, c := range cases {
+		got := totpCode(key, c.counter, 8)
+		if got != c.want {
+			t.Fatalf("counter %d: got %v, want %v", c.counter, got, c.want)
+		}
+	}
+}
+
+// TestTOTPProviderVerifySkewWindow checks that Verify accepts codes within Skew periods of now,
+// and rejects codes just outside that window.
+func TestTOTPProviderVerifySkewWindow(t *testing.T) {
+	store := newFakeTOTPStore()
+	provider := NewTOTPProvider(store, "authaus-test")
+	secret, _, err := provider.Enroll("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeBase32Secret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	period := provider.period()
+	now := time.Now()
+	counterAt := func(offsetPeriods int) uint64 {
+		return uint64(now.Add(time.Duration(offsetPeriods)*period).UnixNano()) / uint64(period)
+	}
+
+	within := []int{-1, 0, 1}
+	for _, offset := range within {
+		code := totpCode(key, counterAt(offset), provider.digits())
+		if err := provider.Verify("alice", code); err != nil {
+			t.Fatalf("offset %d periods should be within the default skew window: %v", offset, err)
+		}
+	}
+
+	outside := []int{-2, 2}
+	for _, offset := range outside {
+		code := totpCode(key, counterAt(offset), provider.digits())
+		if err := provider.Verify("alice", code); err == nil {
+			t.Fatalf("offset %d periods should fall outside the default skew window", offset)
+		}
+	}
+}
+
+// decodeBase32Secret mirrors the encoding TOTPProvider.Enroll uses, so tests can recover the raw
+// key bytes from the secret it hands back.
+func decodeBase32Secret(secret string) ([]byte, error) {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+func TestTOTPProviderBackupCodeIsOneTimeUse(t *testing.T) {
+	store := newFakeTOTPStore()
+	provider := NewTOTPProvider(store, "authaus-test")
+	if _, _, err := provider.Enroll("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddBackupCodes(CanonicalizeIdentity("alice"), []string{HashBackupCode("ABCDE12345")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Verify("alice", "ABCDE12345"); err != nil {
+		t.Fatalf("backup code should be accepted the first time: %v", err)
+	}
+	if err := provider.Verify("alice", "ABCDE12345"); err == nil {
+		t.Fatalf("backup code must not be accepted a second time")
+	}
+}
@@ -0,0 +1,375 @@
+package authaus
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mongoConnect opens a *mongo.Database for 'config', shared by all four Mongo-backed stores.
+func mongoConnect(config *ConfigMongo) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(config.URI)
+	if config.TLS {
+		opts.SetTLSConfig(&tls.Config{})
+	}
+	if config.Username != "" {
+		opts.SetAuth(options.Credential{Username: config.Username, Password: config.Password})
+	}
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(context.Background(), nil); err != nil {
+		return nil, err
+	}
+	return client.Database(config.Database), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// PermitDB
+
+type permitDoc struct {
+	Identity string `bson:"_id"`
+	Roles    []byte `bson:"roles"`
+}
+
+type permitDB_Mongo struct {
+	permits *mongo.Collection
+}
+
+// NewPermitDB_Mongo creates a PermitDB backed by MongoDB. SetPermit is an atomic upsert, just
+// like the SQL implementation's "insert or update" statement.
+func NewPermitDB_Mongo(config *ConfigMongo) (PermitDB, error) {
+	db, err := mongoConnect(config)
+	if err != nil {
+		return nil, err
+	}
+	return &permitDB_Mongo{permits: db.Collection("Permit")}, nil
+}
+
+func (x *permitDB_Mongo) GetPermit(identity string) (*Permit, error) {
+	identity = CanonicalizeIdentity(identity)
+	doc := permitDoc{}
+	err := x.permits.FindOne(context.Background(), bson.M{"_id": identity}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrIdentityPermitNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Permit{Roles: doc.Roles}, nil
+}
+
+func (x *permitDB_Mongo) GetPermits() (map[string]*Permit, error) {
+	cur, err := x.permits.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	permits := map[string]*Permit{}
+	for cur.Next(context.Background()) {
+		doc := permitDoc{}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		permits[doc.Identity] = &Permit{Roles: doc.Roles}
+	}
+	return permits, cur.Err()
+}
+
+func (x *permitDB_Mongo) SetPermit(identity string, permit *Permit) error {
+	identity = CanonicalizeIdentity(identity)
+	_, err := x.permits.UpdateOne(context.Background(),
+		bson.M{"_id": identity},
+		bson.M{"$set": bson.M{"roles": permit.Roles}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (x *permitDB_Mongo) Close() {
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// SessionDB
+
+type sessionDoc struct {
+	SessionKey      string               `bson:"_id"`
+	Identity        string               `bson:"identity"`
+	Expires         time.Time            `bson:"expires"`
+	Permit          []byte               `bson:"permit"`
+	IdentityVersion uint64               `bson:"identityVersion"`
+	Stage           TokenStage           `bson:"stage"`
+	IssuedAt        time.Time            `bson:"issuedAt"`
+	Lifetime        SessionLifetimeClass `bson:"lifetime"`
+	ClientInfo      string               `bson:"clientInfo"`
+	LastSeen        time.Time            `bson:"lastSeen"`
+}
+
+type sessionDB_Mongo struct {
+	sessions *mongo.Collection
+}
+
+// NewSessionDB_Mongo creates a SessionDB backed by MongoDB, with a TTL index on Expires so that
+// Mongo itself reaps expired sessions - there is no need for authaus-side cleanup.
+func NewSessionDB_Mongo(config *ConfigMongo) (SessionDB, error) {
+	db, err := mongoConnect(config)
+	if err != nil {
+		return nil, err
+	}
+	sessions := db.Collection("Session")
+	_, err = sessions.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = sessions.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.M{"identity": 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sessionDB_Mongo{sessions: sessions}, nil
+}
+
+func (x *sessionDB_Mongo) Read(sessionkey string) (*Token, error) {
+	doc := sessionDoc{}
+	err := x.sessions.FindOne(context.Background(), bson.M{"_id": sessionkey}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidSessionToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{
+		Identity:        doc.Identity,
+		Expires:         doc.Expires,
+		IdentityVersion: doc.IdentityVersion,
+		Stage:           doc.Stage,
+		IssuedAt:        doc.IssuedAt,
+		Lifetime:        doc.Lifetime,
+		ClientInfo:      doc.ClientInfo,
+		LastSeen:        doc.LastSeen,
+	}
+	if err := token.Permit.Deserialize(string(doc.Permit)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (x *sessionDB_Mongo) Write(sessionkey string, token *Token) error {
+	doc := sessionDoc{
+		SessionKey:      sessionkey,
+		Identity:        CanonicalizeIdentity(token.Identity),
+		Expires:         token.Expires,
+		Permit:          []byte(token.Permit.Serialize()),
+		IdentityVersion: token.IdentityVersion,
+		Stage:           token.Stage,
+		IssuedAt:        token.IssuedAt,
+		Lifetime:        token.Lifetime,
+		ClientInfo:      token.ClientInfo,
+		LastSeen:        token.LastSeen,
+	}
+	_, err := x.sessions.UpdateOne(context.Background(),
+		bson.M{"_id": sessionkey},
+		bson.M{"$set": doc},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (x *sessionDB_Mongo) Delete(sessionkey string) error {
+	_, err := x.sessions.DeleteOne(context.Background(), bson.M{"_id": sessionkey})
+	return err
+}
+
+func (x *sessionDB_Mongo) InvalidateSessionsForIdentity(identity string) error {
+	_, err := x.sessions.DeleteMany(context.Background(), bson.M{"identity": CanonicalizeIdentity(identity)})
+	return err
+}
+
+func (x *sessionDB_Mongo) PermitChanged(identity string, permit *Permit) error {
+	_, err := x.sessions.UpdateMany(context.Background(),
+		bson.M{"identity": CanonicalizeIdentity(identity)},
+		bson.M{"$set": bson.M{"permit": []byte(permit.Serialize())}})
+	return err
+}
+
+func (x *sessionDB_Mongo) ListSessionsForIdentity(identity string) ([]SessionInfo, error) {
+	cur, err := x.sessions.Find(context.Background(), bson.M{"identity": CanonicalizeIdentity(identity)})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	sessions := []SessionInfo{}
+	for cur.Next(context.Background()) {
+		doc := sessionDoc{}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, SessionInfo{
+			SessionKey: doc.SessionKey,
+			Identity:   doc.Identity,
+			IssuedAt:   doc.IssuedAt,
+			Expires:    doc.Expires,
+			Lifetime:   doc.Lifetime,
+			ClientInfo: doc.ClientInfo,
+		})
+	}
+	return sessions, cur.Err()
+}
+
+func (x *sessionDB_Mongo) Close() {
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// AuthenticationDB (Authenticator)
+
+type authDoc struct {
+	Identity string `bson:"_id"`
+	Password []byte `bson:"password"` // bcrypt hash
+}
+
+type authenticationDB_Mongo struct {
+	identities *mongo.Collection
+}
+
+// NewAuthenticationDB_Mongo creates an Authenticator backed by MongoDB.
+func NewAuthenticationDB_Mongo(config *ConfigMongo) (Authenticator, error) {
+	db, err := mongoConnect(config)
+	if err != nil {
+		return nil, err
+	}
+	return &authenticationDB_Mongo{identities: db.Collection("Identity")}, nil
+}
+
+func (x *authenticationDB_Mongo) Authenticate(identity, password string) error {
+	identity = CanonicalizeIdentity(identity)
+	doc := authDoc{}
+	err := x.identities.FindOne(context.Background(), bson.M{"_id": identity}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return ErrIdentityAuthNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword(doc.Password, []byte(password)) != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func (x *authenticationDB_Mongo) SetPassword(identity, password string) error {
+	identity = CanonicalizeIdentity(identity)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = x.identities.UpdateOne(context.Background(),
+		bson.M{"_id": identity},
+		bson.M{"$set": bson.M{"password": hashed}})
+	return err
+}
+
+func (x *authenticationDB_Mongo) CreateIdentity(identity, password string) error {
+	identity = CanonicalizeIdentity(identity)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = x.identities.InsertOne(context.Background(), authDoc{Identity: identity, Password: hashed})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityExists
+	}
+	return err
+}
+
+func (x *authenticationDB_Mongo) GetIdentities() ([]string, error) {
+	cur, err := x.identities.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	identities := []string{}
+	for cur.Next(context.Background()) {
+		doc := authDoc{}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		identities = append(identities, doc.Identity)
+	}
+	return identities, cur.Err()
+}
+
+func (x *authenticationDB_Mongo) Close() {
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// RoleGroupDB
+
+type roleGroupDoc struct {
+	Name  string `bson:"_id"`
+	Roles []byte `bson:"roles"`
+}
+
+type roleGroupDB_Mongo struct {
+	groups *mongo.Collection
+}
+
+// NewRoleGroupDB_Mongo creates a RoleGroupDB backed by MongoDB.
+func NewRoleGroupDB_Mongo(config *ConfigMongo) (RoleGroupDB, error) {
+	db, err := mongoConnect(config)
+	if err != nil {
+		return nil, err
+	}
+	return &roleGroupDB_Mongo{groups: db.Collection("RoleGroup")}, nil
+}
+
+func (x *roleGroupDB_Mongo) GetByName(name string) (*RoleGroup, error) {
+	doc := roleGroupDoc{}
+	err := x.groups.FindOne(context.Background(), bson.M{"_id": name}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RoleGroup{Name: doc.Name, Roles: doc.Roles}, nil
+}
+
+func (x *roleGroupDB_Mongo) GetRoleGroupDB() ([]*RoleGroup, error) {
+	cur, err := x.groups.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	groups := []*RoleGroup{}
+	for cur.Next(context.Background()) {
+		doc := roleGroupDoc{}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		groups = append(groups, &RoleGroup{Name: doc.Name, Roles: doc.Roles})
+	}
+	return groups, cur.Err()
+}
+
+func (x *roleGroupDB_Mongo) InsertOrUpdate(group *RoleGroup) error {
+	_, err := x.groups.UpdateOne(context.Background(),
+		bson.M{"_id": group.Name},
+		bson.M{"$set": bson.M{"roles": group.Roles}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (x *roleGroupDB_Mongo) Close() {
+}
@@ -0,0 +1,54 @@
+package authaus
+
+import "time"
+
+// LoginOptions controls how Central.LoginWithOptions creates a session.
+type LoginOptions struct {
+	// StayLoggedIn selects Central.LongSessionExpiresAfter as the session's expiry, instead of
+	// Central.NewSessionExpiresAfter - the usual "remember me" checkbox.
+	StayLoggedIn bool
+
+	// MaxAge, if non-zero, overrides whichever expiry StayLoggedIn would otherwise select.
+	MaxAge time.Duration
+
+	// ClientInfo is recorded on the resulting Token (typically a user-agent and/or IP
+	// address), so that ListSessionsForIdentity has something to show the user.
+	ClientInfo string
+}
+
+// SessionInfo describes one active session, as returned by Central.ListSessionsForIdentity.
+type SessionInfo struct {
+	SessionKey string
+	Identity   string
+	IssuedAt   time.Time
+	Expires    time.Time
+	Lifetime   SessionLifetimeClass
+	ClientInfo string
+}
+
+// SessionLister is implemented by SessionDB backends that can enumerate the sessions belonging
+// to an identity. Not every backend can do this - signed sessions aren't tracked individually -
+// so Central.ListSessionsForIdentity returns ErrUnsupported when the configured sessionDB
+// doesn't implement it.
+type SessionLister interface {
+	ListSessionsForIdentity(identity string) ([]SessionInfo, error)
+}
+
+// ListSessionsForIdentity returns every active session belonging to 'identity', so that a user
+// can review - and then RevokeSession - their own logins.
+func (x *Central) ListSessionsForIdentity(identity string) ([]SessionInfo, error) {
+	// x.sessionDB is (usually) a cachedSessionDB wrapping the real store, which doesn't itself
+	// implement SessionLister, so this asserts against rawSessionDB - the store as it was handed
+	// to NewCentral/EnableSignedSessions, before any caching wrapper.
+	lister, ok := x.rawSessionDB.(SessionLister)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return lister.ListSessionsForIdentity(CanonicalizeIdentity(identity))
+}
+
+// RevokeSession terminates a single session, identified by its session key. Unlike
+// InvalidateSessionsForIdentity, this does not touch any of the identity's other sessions.
+func (x *Central) RevokeSession(sessionkey string) error {
+	return x.sessionDB.Delete(sessionkey)
+}
@@ -0,0 +1,40 @@
+package authaus
+
+import "testing"
+
+type fakeListingSessionDB struct {
+	*fakeSessionDB
+	sessions []SessionInfo
+}
+
+func (f *fakeListingSessionDB) ListSessionsForIdentity(identity string) ([]SessionInfo, error) {
+	return f.sessions, nil
+}
+
+// Regression test: ListSessionsForIdentity used to type-assert against x.sessionDB, which
+// NewCentral always wraps in a cachedSessionDB that doesn't implement SessionLister - so the
+// assertion could never succeed against a real deployment's store. It must consult the
+// unwrapped rawSessionDB instead.
+func TestListSessionsForIdentityForwardsToRawSessionDB(t *testing.T) {
+	fake := &fakeListingSessionDB{
+		fakeSessionDB: newFakeSessionDB(),
+		sessions:      []SessionInfo{{SessionKey: "abc", Identity: "alice"}},
+	}
+	c := &Central{sessionDB: newCachedSessionDB(fake), rawSessionDB: fake}
+
+	sessions, err := c.ListSessionsForIdentity("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].SessionKey != "abc" {
+		t.Fatalf("got %+v", sessions)
+	}
+}
+
+func TestListSessionsForIdentityUnsupportedWithoutLister(t *testing.T) {
+	sdb := newFakeSessionDB()
+	c := &Central{sessionDB: sdb, rawSessionDB: sdb}
+	if _, err := c.ListSessionsForIdentity("alice"); err != ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package authaus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSigner issues and verifies cryptographically signed session tokens, as an alternative
+// to the opaque random strings produced by generateSessionKey(). A signed token carries
+// everything needed to reconstruct its Token, so Verify never needs to touch a database.
+type TokenSigner interface {
+	// Sign produces an opaque signed token string that encodes everything in 'token'.
+	Sign(token *Token) (string, error)
+
+	// Verify decodes and authenticates a token produced by Sign, returning the Token it
+	// encodes. It returns ErrInvalidSessionToken if the signature does not check out against
+	// any currently trusted key, or if the token is malformed.
+	Verify(signed string) (*Token, error)
+}
+
+// signedTokenPayload is the JSON structure embedded inside a signed token. It deliberately
+// mirrors Token's fields, rather than embedding Token directly, so that the wire format does
+// not change shape just because Token grows a new field.
+type signedTokenPayload struct {
+	Identity        string
+	Expires         int64 // UnixNano
+	Permit          string
+	IdentityVersion uint64
+	Stage           TokenStage
+	IssuedAt        int64 // UnixNano
+	Lifetime        SessionLifetimeClass
+	ClientInfo      string
+}
+
+// HMACTokenSigner is a TokenSigner that uses HMAC-SHA256. It supports key rotation: Verify
+// accepts a signature produced by any key in TrustedKeys, while Sign always uses the key
+// named by ActiveKeyID. To rotate keys, add the new key with AddTrustedKey, deploy, then
+// switch ActiveKeyID to it once all instances have the new key loaded.
+type HMACTokenSigner struct {
+	ActiveKeyID string
+	TrustedKeys map[string][]byte // keyID -> secret
+}
+
+// NewHMACTokenSigner creates an HMACTokenSigner with a single, active signing key.
+func NewHMACTokenSigner(activeKeyID string, activeKey []byte) *HMACTokenSigner {
+	return &HMACTokenSigner{
+		ActiveKeyID: activeKeyID,
+		TrustedKeys: map[string][]byte{activeKeyID: activeKey},
+	}
+}
+
+// AddTrustedKey registers an additional verification key, without making it the active
+// signing key. Use this to keep accepting tokens signed under an old key while rotating to a
+// new one.
+func (h *HMACTokenSigner) AddTrustedKey(keyID string, key []byte) {
+	h.TrustedKeys[keyID] = key
+}
+
+func (h *HMACTokenSigner) Sign(token *Token) (string, error) {
+	key, ok := h.TrustedKeys[h.ActiveKeyID]
+	if !ok {
+		return "", errors.New("HMACTokenSigner: active key '" + h.ActiveKeyID + "' is not in TrustedKeys")
+	}
+	payload := signedTokenPayload{
+		Identity:        token.Identity,
+		Expires:         token.Expires.UnixNano(),
+		Permit:          token.Permit.Serialize(),
+		IdentityVersion: token.IdentityVersion,
+		Stage:           token.Stage,
+		IssuedAt:        token.IssuedAt.UnixNano(),
+		Lifetime:        token.Lifetime,
+		ClientInfo:      token.ClientInfo,
+	}
+	payloadJSON, err := json.Marshal(&payload)
+	if err != nil {
+		return "", err
+	}
+	payloadPart := base64.RawURLEncoding.EncodeToString(payloadJSON)
+	return h.ActiveKeyID + "." + payloadPart + "." + h.sign(h.ActiveKeyID, payloadPart, key), nil
+}
+
+func (h *HMACTokenSigner) Verify(signed string) (*Token, error) {
+	parts := strings.SplitN(signed, ".", 3)
+	if len(parts) != 3 {
+		return nil, ErrInvalidSessionToken
+	}
+	keyID, payloadPart, sig := parts[0], parts[1], parts[2]
+	key, ok := h.TrustedKeys[keyID]
+	if !ok {
+		return nil, ErrInvalidSessionToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(h.sign(keyID, payloadPart, key))) {
+		return nil, ErrInvalidSessionToken
+	}
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	payload := signedTokenPayload{}
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	token := &Token{
+		Identity:        payload.Identity,
+		Expires:         time.Unix(0, payload.Expires),
+		IdentityVersion: payload.IdentityVersion,
+		Stage:           payload.Stage,
+		IssuedAt:        time.Unix(0, payload.IssuedAt),
+		Lifetime:        payload.Lifetime,
+		ClientInfo:      payload.ClientInfo,
+	}
+	if err := token.Permit.Deserialize(payload.Permit); err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	return token, nil
+}
+
+func (h *HMACTokenSigner) sign(keyID, payloadPart string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyID + "." + payloadPart))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IdentityVersionDB tracks a monotonic version number per identity, so that SetPermit and
+// SetPassword can invalidate outstanding signed tokens by bumping the version, without having
+// to touch every token that was ever issued. A never-seen identity is at version 0.
+type IdentityVersionDB interface {
+	GetIdentityVersion(identity string) (uint64, error)
+	BumpIdentityVersion(identity string) (uint64, error)
+}
+
+// RevokedSessionDB tracks individually-revoked signed tokens, so that SignedSessionDB.Delete
+// (and therefore Central.Logout/RevokeSession) can terminate exactly one session without
+// bumping the identity's version and invalidating every other outstanding token for that
+// identity - see InvalidateSessionsForIdentity for the "everywhere" version of revocation.
+// Entries are keyed by a hash of the signed token string, not the token itself, so that a
+// revoked token's Permit/ClientInfo never need to be persisted; they're only meaningful up to
+// the revoked token's own Expires, since nothing can present it again afterwards anyway.
+type RevokedSessionDB interface {
+	// Revoke records tokenHash as revoked until expires.
+	Revoke(tokenHash string, expires time.Time) error
+
+	// IsRevoked reports whether tokenHash has been revoked and has not yet expired.
+	IsRevoked(tokenHash string) (bool, error)
+
+	// PurgeExpired deletes revocation records whose expires time has passed, so the store
+	// doesn't grow forever.
+	PurgeExpired() error
+}
+
+// hashSignedToken reduces a signed token string to the key RevokedSessionDB stores it under,
+// so that Delete never has to write a session's Permit/ClientInfo to the revocation store.
+func hashSignedToken(signed string) string {
+	sum := sha256.Sum256([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SignedSessionDB is a SessionDB that validates tokens locally using a TokenSigner, instead of
+// performing a database round-trip on every request. Versions (the actual source of truth for
+// identity-wide invalidation) is consulted on every Read, since IdentityVersionDB is shared by
+// every Central instance pointed at the same database - a local "have I personally seen an
+// invalidation" flag would leave every *other* instance accepting a revoked token indefinitely.
+// versionCache only bounds how often that lookup happens, for VersionCacheTTL at a time; it is
+// never treated as a substitute for the database, only a rate limit on how often it is asked.
+// Revoked backs single-session revocation (Delete) the same way, since a signed token carries
+// no per-session state of its own to check against.
+type SignedSessionDB struct {
+	Signer   TokenSigner
+	Versions IdentityVersionDB
+	Revoked  RevokedSessionDB
+
+	// VersionCacheTTL controls how long a GetIdentityVersion result is trusted before Read asks
+	// Versions again. It bounds the worst-case delay before a revocation made on one instance is
+	// honoured by another. Defaults to 2 seconds if zero.
+	VersionCacheTTL time.Duration
+
+	versionCache sync.Map // canonical identity -> *cachedVersion
+}
+
+type cachedVersion struct {
+	version   uint64
+	fetchedAt time.Time
+}
+
+// NewSignedSessionDB creates a SessionDB that is backed by 'signer' for token validation,
+// 'versions' for tracking identity-wide invalidation, and 'revoked' for single-session
+// invalidation.
+func NewSignedSessionDB(signer TokenSigner, versions IdentityVersionDB, revoked RevokedSessionDB) *SignedSessionDB {
+	return &SignedSessionDB{
+		Signer:   signer,
+		Versions: versions,
+		Revoked:  revoked,
+	}
+}
+
+func (s *SignedSessionDB) Read(sessionkey string) (*Token, error) {
+	token, err := s.Signer.Verify(sessionkey)
+	if err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	if revoked, err := s.Revoked.IsRevoked(hashSignedToken(sessionkey)); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrInvalidSessionToken
+	}
+	canon := CanonicalizeIdentity(token.Identity)
+	current, err := s.currentVersion(canon)
+	if err != nil {
+		return nil, err
+	}
+	if token.IdentityVersion < current {
+		return nil, ErrInvalidSessionToken
+	}
+	return token, nil
+}
+
+// currentVersion returns canon's identity version, re-fetching from Versions once every
+// VersionCacheTTL rather than on every single Read.
+func (s *SignedSessionDB) currentVersion(canon string) (uint64, error) {
+	ttl := s.VersionCacheTTL
+	if ttl <= 0 {
+		ttl = 2 * time.Second
+	}
+	if cached, ok := s.versionCache.Load(canon); ok {
+		cv := cached.(*cachedVersion)
+		if time.Since(cv.fetchedAt) < ttl {
+			return cv.version, nil
+		}
+	}
+	version, err := s.Versions.GetIdentityVersion(canon)
+	if err != nil {
+		return 0, err
+	}
+	s.versionCache.Store(canon, &cachedVersion{version: version, fetchedAt: time.Now()})
+	return version, nil
+}
+
+// Write is a no-op for SignedSessionDB: the token itself is the session state, so there is
+// nothing further to persist. The sessionkey handed back to callers must be the string
+// produced by Signer.Sign.
+func (s *SignedSessionDB) Write(sessionkey string, token *Token) error {
+	return nil
+}
+
+// Delete revokes exactly the one session named by sessionkey, via Revoked - it must not touch
+// any of the identity's other outstanding tokens (compare InvalidateSessionsForIdentity, which
+// deliberately does invalidate everything). This is what backs Central.Logout and
+// Central.RevokeSession.
+func (s *SignedSessionDB) Delete(sessionkey string) error {
+	token, err := s.Signer.Verify(sessionkey)
+	if err != nil {
+		// Already unusable, so there's nothing to invalidate.
+		return nil
+	}
+	return s.Revoked.Revoke(hashSignedToken(sessionkey), token.Expires)
+}
+
+func (s *SignedSessionDB) InvalidateSessionsForIdentity(identity string) error {
+	canon := CanonicalizeIdentity(identity)
+	version, err := s.Versions.BumpIdentityVersion(canon)
+	if err != nil {
+		return err
+	}
+	// Update this instance's cache immediately, rather than waiting out VersionCacheTTL, since
+	// we already know the new version - we just bumped it ourselves.
+	s.versionCache.Store(canon, &cachedVersion{version: version, fetchedAt: time.Now()})
+	return nil
+}
+
+// PermitChanged bumps the identity's version, just like InvalidateSessionsForIdentity, since a
+// signed token carries a snapshot of the Permit that is now stale.
+func (s *SignedSessionDB) PermitChanged(identity string, permit *Permit) error {
+	return s.InvalidateSessionsForIdentity(identity)
+}
+
+func (s *SignedSessionDB) Close() {
+}
+
+// newTokenSignerFromConfig builds the TokenSigner described by a ConfigSessionSigning block:
+// one active signing key, plus zero or more additional keys that are trusted for verification
+// only (used while rotating keys).
+func newTokenSignerFromConfig(config *ConfigSessionSigning) (TokenSigner, error) {
+	activeKey, err := base64.StdEncoding.DecodeString(config.ActiveKey)
+	if err != nil {
+		return nil, errors.New("ConfigSessionSigning.ActiveKey is not valid base64: " + err.Error())
+	}
+	signer := NewHMACTokenSigner(config.ActiveKeyID, activeKey)
+	for keyID, encoded := range config.TrustedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("ConfigSessionSigning.TrustedKeys[" + keyID + "] is not valid base64: " + err.Error())
+		}
+		signer.AddTrustedKey(keyID, key)
+	}
+	return signer, nil
+}
+
+// identityVersionDB_SQL is the SQL-backed IdentityVersionDB used by signed sessions. It shares
+// its ConfigDB with SessionDB, since identity versions are just another piece of session state.
+type identityVersionDB_SQL struct {
+	db *sql.DB
+}
+
+// NewIdentityVersionDB_SQL creates an IdentityVersionDB backed by a SQL table of the form:
+//
+//	CREATE TABLE IdentityVersion (Identity VARCHAR(256) PRIMARY KEY, Version BIGINT NOT NULL)
+func NewIdentityVersionDB_SQL(config *ConfigDB) (IdentityVersionDB, error) {
+	db, err := sqlOpenConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &identityVersionDB_SQL{db: db}, nil
+}
+
+func (x *identityVersionDB_SQL) GetIdentityVersion(identity string) (uint64, error) {
+	var version uint64
+	row := x.db.QueryRow(`SELECT "Version" FROM "IdentityVersion" WHERE "Identity" = $1`, identity)
+	if err := row.Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+func (x *identityVersionDB_SQL) BumpIdentityVersion(identity string) (uint64, error) {
+	var version uint64
+	row := x.db.QueryRow(`
+		INSERT INTO "IdentityVersion" ("Identity", "Version") VALUES ($1, 1)
+		ON CONFLICT ("Identity") DO UPDATE SET "Version" = "IdentityVersion"."Version" + 1
+		RETURNING "Version"`, identity)
+	if err := row.Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// revokedSessionDB_SQL is the SQL-backed RevokedSessionDB used by signed sessions' Delete. It
+// shares its ConfigDB with SessionDB and IdentityVersionDB, since this is just another piece of
+// session state.
+type revokedSessionDB_SQL struct {
+	db *sql.DB
+}
+
+// NewRevokedSessionDB_SQL creates a RevokedSessionDB backed by a SQL table of the form:
+//
+//	CREATE TABLE RevokedSession (TokenHash VARCHAR(64) PRIMARY KEY, Expires TIMESTAMP NOT NULL)
+func NewRevokedSessionDB_SQL(config *ConfigDB) (RevokedSessionDB, error) {
+	db, err := sqlOpenConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &revokedSessionDB_SQL{db: db}, nil
+}
+
+func (x *revokedSessionDB_SQL) Revoke(tokenHash string, expires time.Time) error {
+	_, err := x.db.Exec(`
+		INSERT INTO "RevokedSession" ("TokenHash", "Expires") VALUES ($1, $2)
+		ON CONFLICT ("TokenHash") DO UPDATE SET "Expires" = $2`, tokenHash, expires)
+	return err
+}
+
+func (x *revokedSessionDB_SQL) IsRevoked(tokenHash string) (bool, error) {
+	var expires time.Time
+	row := x.db.QueryRow(`SELECT "Expires" FROM "RevokedSession" WHERE "TokenHash" = $1`, tokenHash)
+	if err := row.Scan(&expires); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return time.Now().Before(expires), nil
+}
+
+func (x *revokedSessionDB_SQL) PurgeExpired() error {
+	_, err := x.db.Exec(`DELETE FROM "RevokedSession" WHERE "Expires" < $1`, time.Now())
+	return err
+}
@@ -0,0 +1,131 @@
+package authaus
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeIdentityVersionDB struct {
+	versions map[string]uint64
+}
+
+func newFakeIdentityVersionDB() *fakeIdentityVersionDB {
+	return &fakeIdentityVersionDB{versions: map[string]uint64{}}
+}
+func (f *fakeIdentityVersionDB) GetIdentityVersion(identity string) (uint64, error) {
+	return f.versions[identity], nil
+}
+func (f *fakeIdentityVersionDB) BumpIdentityVersion(identity string) (uint64, error) {
+	f.versions[identity]++
+	return f.versions[identity], nil
+}
+
+type fakeRevokedSessionDB struct {
+	revoked map[string]time.Time
+}
+
+func newFakeRevokedSessionDB() *fakeRevokedSessionDB {
+	return &fakeRevokedSessionDB{revoked: map[string]time.Time{}}
+}
+func (f *fakeRevokedSessionDB) Revoke(tokenHash string, expires time.Time) error {
+	f.revoked[tokenHash] = expires
+	return nil
+}
+func (f *fakeRevokedSessionDB) IsRevoked(tokenHash string) (bool, error) {
+	expires, ok := f.revoked[tokenHash]
+	if !ok {
+		return false, nil
+	}
+	return time.Now().Before(expires), nil
+}
+func (f *fakeRevokedSessionDB) PurgeExpired() error {
+	for hash, expires := range f.revoked {
+		if !time.Now().Before(expires) {
+			delete(f.revoked, hash)
+		}
+	}
+	return nil
+}
+
+func TestHMACTokenSignerRoundTrip(t *testing.T) {
+	signer := NewHMACTokenSigner("k1", []byte("secret"))
+	tok := &Token{
+		Identity:   "alice",
+		Expires:    time.Now().Add(time.Hour),
+		Stage:      TokenStagePending,
+		IssuedAt:   time.Now(),
+		Lifetime:   SessionLifetimeLong,
+		ClientInfo: "test-agent",
+	}
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := signer.Verify(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Identity != "alice" || out.Stage != TokenStagePending || out.Lifetime != SessionLifetimeLong || out.ClientInfo != "test-agent" {
+		t.Fatalf("round trip lost fields: %+v", out)
+	}
+}
+
+// Regression test: before this fix, SignedSessionDB only consulted Versions once it had been
+// locally flagged by a call to InvalidateSessionsForIdentity made against that same instance.
+// A separate instance sharing the same IdentityVersionDB never learned about a revocation.
+func TestSignedSessionDBRevocationVisibleAcrossInstances(t *testing.T) {
+	signer := NewHMACTokenSigner("k1", []byte("secret"))
+	versions := newFakeIdentityVersionDB()
+
+	revoked := newFakeRevokedSessionDB()
+	instanceA := NewSignedSessionDB(signer, versions, revoked)
+	instanceB := NewSignedSessionDB(signer, versions, revoked)
+	instanceB.VersionCacheTTL = time.Millisecond
+
+	tok := &Token{Identity: "alice", Expires: time.Now().Add(time.Hour)}
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := instanceB.Read(signed); err != nil {
+		t.Fatalf("token should be valid before any revocation: %v", err)
+	}
+
+	if err := instanceA.InvalidateSessionsForIdentity("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, err := instanceB.Read(signed); err == nil {
+		t.Fatalf("instance B should honour a revocation made on instance A once its version cache expires")
+	}
+}
+
+// Regression test: SignedSessionDB.Delete used to call InvalidateSessionsForIdentity, bumping
+// the identity's version and thereby invalidating every outstanding signed token for that
+// identity, not just the one session key passed in - turning Logout()/RevokeSession() into
+// "log out everywhere" the moment signed sessions are enabled.
+func TestSignedSessionDBDeleteOnlyRevokesTheOneSession(t *testing.T) {
+	signer := NewHMACTokenSigner("k1", []byte("secret"))
+	db := NewSignedSessionDB(signer, newFakeIdentityVersionDB(), newFakeRevokedSessionDB())
+
+	signedA, err := signer.Sign(&Token{Identity: "alice", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedB, err := signer.Sign(&Token{Identity: "alice", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Delete(signedA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Read(signedA); err == nil {
+		t.Fatalf("the deleted session should no longer be valid")
+	}
+	if _, err := db.Read(signedB); err != nil {
+		t.Fatalf("a sibling session for the same identity must survive Delete: %v", err)
+	}
+}